@@ -13,6 +13,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/telegram-photo/middleware"
 	"github.com/telegram-photo/model"
+	"github.com/telegram-photo/service"
 )
 
 // GitHubUser GitHub用户信息
@@ -125,8 +126,11 @@ func getGitHubAccessToken(code string) (string, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	// 发送请求
-	client := &http.Client{}
+	// 发送请求，按proxy.github配置使用代理
+	client, err := service.OutboundHTTPClient(service.ProxyTargetGitHub)
+	if err != nil {
+		return "", err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
@@ -165,7 +169,10 @@ func getGitHubUser(accessToken string) (*GitHubUser, error) {
 	req.Header.Set("Authorization", fmt.Sprintf("token %s", accessToken))
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{}
+	client, err := service.OutboundHTTPClient(service.ProxyTargetGitHub)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -2,16 +2,24 @@ package v1
 
 import (
 	"bytes"
-	"crypto/md5"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
 	"github.com/telegram-photo/model"
 	"github.com/telegram-photo/service"
 )
@@ -104,119 +112,518 @@ func uploadImage(c *gin.Context) {
 	fmt.Printf("上传请求 - 用户ID: %s, ClientIP: %s, 最终使用的IP: %s, X-Real-IP: %s, X-Forwarded-For: %s, RemoteAddr: %s\n",
 		userID, c.ClientIP(), uploadIP, xRealIP, xForwardedFor, remoteAddr)
 
-	// 获取上传的文件
-	file, header, err := c.Request.FormFile("image")
-	if err != nil {
+	// 获取上传的文件，边接收边写入临时文件并检查大小
+	maxSize := maxUploadSize()
+	form, err := readUploadForm(c, maxSize)
+	if errors.Is(err, service.ErrFileTooLarge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("图片大小不能超过%dMB", maxSize/1024/1024)})
+		return
+	}
+	if err != nil || form.file == nil {
+		if form != nil {
+			form.close()
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "未找到上传的图片"})
 		return
 	}
-	defer file.Close()
+	defer form.close()
 
-	// 检查文件大小
-	if header.Size > 20*1024*1024 { // 20MB
-		c.JSON(http.StatusBadRequest, gin.H{"error": "图片大小不能超过20MB"})
+	// 上传模式：photo由Telegram压缩，document保留原图
+	uploadMode := form.values.Get("mode")
+	if uploadMode == "" {
+		uploadMode = service.DefaultUploadMode()
+	}
+	if !service.ValidUploadMode(uploadMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "上传模式只能是photo或document"})
 		return
 	}
 
-	// 读取文件内容用于计算MD5和上传
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取文件失败: %v", err)})
-		return
-	}
-
-	// 计算MD5哈希
-	hash := md5.Sum(fileBytes)
-	md5Hash := fmt.Sprintf("%x", hash)
-
-	// 检查是否已存在相同MD5的文件
-	existingFile, err := model.GetFileByMD5Hash(md5Hash)
-	var fileRecord *model.File
-	var telegramFileID string
-	var isExisting bool
-
-	if err == nil && existingFile != nil {
-		// 文件已存在，检查用户是否已绑定该文件
-		existingImage, err := model.GetImageByFileIDAndUserID(existingFile.ID, userID)
-		if err == nil && existingImage != nil {
-			// 用户已绑定该文件，直接返回现有记录
-			c.JSON(http.StatusOK, gin.H{
-				"message":   "图片已存在",
-				"file_id":   existingFile.TelegramFileID,
-				"proxy_url": fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, existingFile.TelegramFileID),
-				"md5_hash":  md5Hash,
-				"existing":  true,
-			})
+	// 根据文件内容检查是否为允许的图片格式
+	if _, err := form.file.DetectImage(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 异步上传：暂存文件后立即返回任务ID，由后台任务上传
+	if async, _ := strconv.ParseBool(c.DefaultQuery("async", form.values.Get("async"))); async {
+		queue, err := service.UploadJobs()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 			return
 		}
 
-		// 用户未绑定该文件，使用现有文件记录
-		fileRecord = existingFile
-		telegramFileID = existingFile.TelegramFileID
-		isExisting = true
-	} else {
-		// 文件不存在，上传到Telegram并创建新文件记录
-		// 创建新的reader用于上传
-		uploadReader := bytes.NewReader(fileBytes)
-
-		// 上传到Telegram
-		telegramFileID, err = service.UploadImageToTelegram(uploadReader, header.Filename)
+		job, err := queue.Enqueue(form.file.Reader(), service.UploadOptions{
+			UserID:      userID,
+			UploadIP:    uploadIP,
+			Filename:    form.filename,
+			ContentType: form.contentType,
+			Mode:        uploadMode,
+		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("上传图片失败: %v", err)})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		// 创建文件记录
-		fileRecord = &model.File{
-			TelegramFileID: telegramFileID,
-			MD5Hash:        md5Hash,
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":    "已加入上传队列",
+			"job_id":     job.ID,
+			"status":     job.Status,
+			"status_url": fmt.Sprintf("%s://%s/api/v1/image/jobs/%d", getScheme(c), c.Request.Host, job.ID),
+		})
+		return
+	}
+
+	result, err := service.StoreUpload(c.Request.Context(), form.file, service.UploadOptions{
+		UserID:      userID,
+		UploadIP:    uploadIP,
+		Filename:    form.filename,
+		ContentType: form.contentType,
+		Mode:        uploadMode,
+	})
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	respondUpload(c, result, uploadIP)
+}
+
+// uploadErrorStatus 保存上传失败时的状态码，文件内容不是允许的图片时为400
+func uploadErrorStatus(err error) int {
+	if errors.Is(err, service.ErrInvalidImage) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// uploadForm 上传图片的表单
+type uploadForm struct {
+	file        *service.UploadFile
+	filename    string
+	contentType string
+	values      url.Values
+}
+
+// readUploadForm 流式读取multipart表单，image字段的文件直接写入临时文件，不将请求体读入内存
+func readUploadForm(c *gin.Context, maxSize int64) (*uploadForm, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &uploadForm{values: url.Values{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.close()
+			return nil, err
+		}
+
+		switch {
+		case part.FormName() == "image" && part.FileName() != "" && form.file == nil:
+			form.file, err = service.SpoolUpload(part, maxSize)
+			form.filename = part.FileName()
+			form.contentType = part.Header.Get("Content-Type")
+		case part.FileName() == "":
+			// 普通字段只有mode、async等短值
+			var value []byte
+			value, err = io.ReadAll(io.LimitReader(part, 1024))
+			form.values.Add(part.FormName(), string(value))
+		}
+		part.Close()
+		if err != nil {
+			form.close()
+			return nil, err
 		}
+	}
+}
 
-		if err := model.CreateFile(fileRecord); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存文件记录失败: %v", err)})
-			return
+// close 删除临时文件
+func (f *uploadForm) close() {
+	if f.file != nil {
+		f.file.Close()
+	}
+}
+
+// respondUpload 返回上传结果
+func respondUpload(c *gin.Context, result *service.UploadResult, uploadIP string) {
+	telegramFileID := result.File.TelegramFileID
+	proxyURL := fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, telegramFileID)
+
+	// 用户已绑定该文件，直接返回现有记录
+	if result.Owned {
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "图片已存在",
+			"file_id":     telegramFileID,
+			"proxy_url":   proxyURL,
+			"md5_hash":    result.MD5Hash,
+			"sha256_hash": result.SHA256Hash,
+			"upload_mode": result.File.UploadMode,
+			"mime_type":   result.File.MimeType,
+			"width":       result.File.Width,
+			"height":      result.File.Height,
+			"size":        result.File.Size,
+			"existing":    true,
+		})
+		return
+	}
+
+	// 返回结果
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "上传成功",
+		"file_id":     telegramFileID,
+		"proxy_url":   proxyURL,
+		"md5_hash":    result.MD5Hash,
+		"sha256_hash": result.SHA256Hash,
+		"upload_mode": result.File.UploadMode,
+		"mime_type":   result.File.MimeType,
+		"width":       result.File.Width,
+		"height":      result.File.Height,
+		"size":        result.File.Size,
+		"existing":    result.Existing,
+		"upload_ip":   uploadIP,
+	})
+}
+
+// uploadImageFromURL 从远程地址下载图片并上传
+func uploadImageFromURL(c *gin.Context) {
+	// 获取用户ID
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	var req struct {
+		URL  string `json:"url" form:"url"`
+		Mode string `json:"mode" form:"mode"`
+	}
+	if err := c.ShouldBind(&req); err != nil || req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未提供图片地址"})
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = service.DefaultUploadMode()
+	}
+	if !service.ValidUploadMode(req.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "上传模式只能是photo或document"})
+		return
+	}
+
+	remote, err := service.FetchRemoteImage(c.Request.Context(), req.URL, maxUploadSize())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+		return
+	}
+	defer remote.File.Close()
+
+	uploadIP := getRealIP(c)
+	result, err := service.StoreUpload(c.Request.Context(), remote.File, service.UploadOptions{
+		UserID:      userID,
+		UploadIP:    uploadIP,
+		Filename:    remote.Filename,
+		ContentType: remote.ContentType,
+		Mode:        req.Mode,
+	})
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	respondUpload(c, result, uploadIP)
+}
+
+// batchUploadResult 批量上传中单个文件的结果
+type batchUploadResult struct {
+	Filename   string `json:"filename"`
+	Status     string `json:"status"`
+	FileID     string `json:"file_id,omitempty"`
+	ProxyURL   string `json:"proxy_url,omitempty"`
+	MD5Hash    string `json:"md5_hash,omitempty"`
+	SHA256Hash string `json:"sha256_hash,omitempty"`
+	UploadMode string `json:"upload_mode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 批量上传中单个文件的状态
+const (
+	batchStatusUploaded = "uploaded"
+	batchStatusExisting = "existing"
+	batchStatusError    = "error"
+)
+
+// batchFile 批量上传中已写入临时文件的图片
+type batchFile struct {
+	upload      *service.UploadFile
+	contentType string
+}
+
+// uploadImageBatch 批量上传图片，多个文件并发上传，返回每个文件的结果
+// 请求体流式读取，各文件先写入临时文件，请求总大小受upload.batch_max_total_mb限制
+func uploadImageBatch(c *gin.Context) {
+	// 获取用户ID
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	uploadIP := getRealIP(c)
+
+	maxFiles := viper.GetInt("upload.batch_max_files")
+	if maxFiles <= 0 {
+		maxFiles = 50
+	}
+
+	maxTotal := viper.GetInt64("upload.batch_max_total_mb")
+	if maxTotal <= 0 {
+		maxTotal = 500
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTotal*1024*1024)
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
+		return
+	}
+
+	maxSize := maxUploadSize()
+	uploadMode := service.DefaultUploadMode()
+	var results []batchUploadResult
+	var files []batchFile
+	defer func() {
+		for _, f := range files {
+			if f.upload != nil {
+				f.upload.Close()
+			}
 		}
+	}()
 
-		// 直接使用创建后的fileRecord，它应该已经有ID了
-		// 如果ID为0，说明创建过程中出现了问题
-		if fileRecord.ID == 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建文件记录后未获取到有效ID"})
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("请求总大小不能超过%dMB", maxTotal)})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误"})
 			return
 		}
 
-		isExisting = false
+		switch {
+		// 支持images和image两种字段名
+		case (part.FormName() == "images" || part.FormName() == "image") && part.FileName() != "":
+			if len(results) >= maxFiles {
+				part.Close()
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多上传%d个文件", maxFiles)})
+				return
+			}
+
+			result := batchUploadResult{Filename: part.FileName()}
+			upload, err := service.SpoolUpload(part, maxSize)
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					part.Close()
+					c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("请求总大小不能超过%dMB", maxTotal)})
+					return
+				}
+				result.Status = batchStatusError
+				result.Error = err.Error()
+				if errors.Is(err, service.ErrFileTooLarge) {
+					result.Error = fmt.Sprintf("图片大小不能超过%dMB", maxSize/1024/1024)
+				}
+			}
+			results = append(results, result)
+			files = append(files, batchFile{upload: upload, contentType: part.Header.Get("Content-Type")})
+		case part.FormName() == "mode" && part.FileName() == "":
+			value, _ := io.ReadAll(io.LimitReader(part, 1024))
+			uploadMode = string(value)
+		}
+		part.Close()
+	}
+
+	if len(results) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未找到上传的图片"})
+		return
+	}
+
+	if !service.ValidUploadMode(uploadMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "上传模式只能是photo或document"})
+		return
+	}
+
+	concurrency := viper.GetInt("upload.batch_concurrency")
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	scheme := getScheme(c)
+	host := c.Request.Host
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i := range results {
+		if files[i].upload == nil {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *batchUploadResult, file batchFile) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			upload, err := service.StoreUpload(c.Request.Context(), file.upload, service.UploadOptions{
+				UserID:      userID,
+				UploadIP:    uploadIP,
+				Filename:    result.Filename,
+				ContentType: file.contentType,
+				Mode:        uploadMode,
+			})
+			if err != nil {
+				result.Status = batchStatusError
+				result.Error = err.Error()
+				return
+			}
+
+			result.Status = batchStatusUploaded
+			if upload.Existing {
+				result.Status = batchStatusExisting
+			}
+			result.FileID = upload.File.TelegramFileID
+			result.ProxyURL = fmt.Sprintf("%s://%s/proxy/image/%s", scheme, host, upload.File.TelegramFileID)
+			result.MD5Hash = upload.MD5Hash
+			result.SHA256Hash = upload.SHA256Hash
+			result.UploadMode = upload.File.UploadMode
+		}(&results[i], files[i])
+	}
+	wg.Wait()
+
+	counts := map[string]int{batchStatusUploaded: 0, batchStatusExisting: 0, batchStatusError: 0}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("上传完成，成功%d个，已存在%d个，失败%d个", counts[batchStatusUploaded], counts[batchStatusExisting], counts[batchStatusError]),
+		"total":    len(results),
+		"uploaded": counts[batchStatusUploaded],
+		"existing": counts[batchStatusExisting],
+		"failed":   counts[batchStatusError],
+		"results":  results,
+	})
+}
+
+// getUploadJob 查询异步上传任务的状态
+func getUploadJob(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "任务ID格式错误"})
+		return
 	}
 
-	// 创建图片记录，关联用户和文件
-	image := &model.Image{
-		FileID:   fileRecord.ID,
-		UserID:   userID,
-		UploadIP: uploadIP,
+	job, err := model.GetUploadJobByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
 	}
 
-	// 确保uploadIP不为空
-	if image.UploadIP == "" {
-		image.UploadIP = "unknown"
+	// 检查权限
+	if job.UserID != c.GetString("user_id") && !c.GetBool("is_admin") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
 	}
 
-	fmt.Printf("保存到数据库的IP: %s\n", image.UploadIP)
+	c.JSON(http.StatusOK, uploadJobResponse(c, job))
+}
 
-	if err := model.CreateImage(image); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存图片记录失败: %v", err)})
+// adminListUploadJobs 管理员查询上传任务，可按状态和用户筛选
+func adminListUploadJobs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	jobs, total, err := model.GetUploadJobs(c.Query("status"), c.Query("user_id"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取上传任务失败: %v", err)})
 		return
 	}
 
-	// 返回结果
+	result := make([]gin.H, 0, len(jobs))
+	for i := range jobs {
+		item := uploadJobResponse(c, &jobs[i])
+		item["user_id"] = jobs[i].UserID
+		item["upload_ip"] = jobs[i].UploadIP
+		result = append(result, item)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message":   "上传成功",
-		"file_id":   telegramFileID,
-		"proxy_url": fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, telegramFileID),
-		"md5_hash":  md5Hash,
-		"existing":  isExisting,
-		"upload_ip": uploadIP,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"jobs":      result,
 	})
 }
 
+// uploadJobResponse 构建上传任务的返回结果
+func uploadJobResponse(c *gin.Context, job *model.UploadJob) gin.H {
+	result := gin.H{
+		"id":           job.ID,
+		"status":       job.Status,
+		"filename":     job.Filename,
+		"size":         job.Size,
+		"attempts":     job.Attempts,
+		"max_attempts": job.MaxAttempts,
+		"progress":     0,
+		"created_at":   job.CreatedAt,
+		"updated_at":   job.UpdatedAt,
+	}
+
+	switch job.Status {
+	case model.UploadJobProcessing:
+		if queue, err := service.UploadJobs(); err == nil && job.Size > 0 {
+			if uploaded, ok := queue.Progress(job.ID); ok {
+				result["uploaded"] = uploaded
+				result["progress"] = int(uploaded * 100 / job.Size)
+			}
+		}
+	case model.UploadJobSucceeded:
+		result["progress"] = 100
+		result["file_id"] = job.TelegramFileID
+		result["proxy_url"] = fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, job.TelegramFileID)
+		result["existing"] = job.Existing
+	}
+
+	if job.Error != "" {
+		result["error"] = job.Error
+	}
+	if job.Status == model.UploadJobPending && job.Attempts > 0 {
+		result["next_run_at"] = job.NextRunAt
+	}
+	if job.FinishedAt != nil {
+		result["finished_at"] = job.FinishedAt
+	}
+
+	return result
+}
+
 // listImages 获取用户的图片列表
 func listImages(c *gin.Context) {
 	// 获取用户ID
@@ -259,18 +666,44 @@ func listImages(c *gin.Context) {
 		}
 	}
 
+	// 批量查询图片的元数据
+	imageIDs := make([]uint, 0, len(images))
+	for _, img := range images {
+		imageIDs = append(imageIDs, img.ID)
+	}
+	metadata, err := model.GetImageMetadataByImageIDs(imageIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片元数据失败: %v", err)})
+		return
+	}
+
 	for _, img := range images {
 		file, err := model.GetFileByID(img.FileID)
 		if err != nil {
 			continue
 		}
-		result = append(result, map[string]interface{}{
-			"id":         img.ID,
-			"file_id":    file.TelegramFileID,
-			"md5_hash":   file.MD5Hash,
-			"created_at": img.CreatedAt,
-			"proxy_url":  fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, file.TelegramFileID),
-		})
+		item := map[string]interface{}{
+			"id":                img.ID,
+			"file_id":           file.TelegramFileID,
+			"md5_hash":          file.MD5Hash,
+			"sha256_hash":       file.SHA256Hash,
+			"mime_type":         file.MimeType,
+			"width":             file.Width,
+			"height":            file.Height,
+			"size":              file.Size,
+			"original_filename": file.OriginalFilename,
+			"created_at":        img.CreatedAt,
+			"proxy_url":         fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, file.TelegramFileID),
+			"metadata":          nil,
+		}
+		if url := thumbnailURL(c, file); url != "" {
+			item["thumbnail_url"] = url
+		}
+		// 元数据功能上线前上传的图片没有元数据
+		if m, ok := metadata[img.ID]; ok {
+			item["metadata"] = m
+		}
+		result = append(result, item)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -303,10 +736,9 @@ func deleteImage(c *gin.Context) {
 		return
 	}
 
-	// 检查权限
+	// 检查权限，管理员也只能删除自己的图片
 	userID := c.GetString("user_id")
-	isAdmin := c.GetBool("is_admin")
-	if userID != image.UserID && !isAdmin {
+	if userID != image.UserID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权删除该图片"})
 		return
 	}
@@ -330,6 +762,13 @@ func proxyImage(c *gin.Context) {
 		return
 	}
 
+	// 缩略图尺寸，不指定时返回原图
+	size := c.Query("size")
+	if _, ok := thumbnailSizes[size]; size != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size只能是small、medium或large"})
+		return
+	}
+
 	// 查询数据库验证文件ID是否存在
 	file, err := model.GetFileByTelegramFileID(telegramFileID)
 	if err != nil {
@@ -337,39 +776,571 @@ func proxyImage(c *gin.Context) {
 		return
 	}
 
-	// 从Telegram获取图片
-	imageURL, err := service.GetTelegramImageURL(file.TelegramFileID)
+	// 从文件所在的存储后端获取图片
+	st, err := service.GetFileStorage(file.Storage, file.TelegramBot)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
 		return
 	}
 
-	// 获取图片内容
-	resp, err := http.Get(imageURL)
+	// 指定了处理参数时返回处理后的图片
+	transform, err := parseTransformOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("下载图片失败: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if transform != nil {
+		serveTransformedImage(c, st, file, size, *transform)
+		return
+	}
+
+	src, err := resolveImageSource(file, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+		return
+	}
+
+	// 没有对应尺寸版本时由原图缩放生成缩略图，原图不超过缩略图尺寸时直接返回原图
+	if size != "" && !src.variant {
+		if !service.CanTransform(file.MimeType) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "该文件不支持缩略图"})
+			return
+		}
+		side := thumbnailSizes[size]
+		if file.Width == 0 || file.Height == 0 || file.Width > side || file.Height > side {
+			serveTransformedImage(c, st, file, "", service.TransformOptions{Width: side, Height: side, Fit: service.FitContain})
+			return
+		}
+		size = ""
+	}
+
+	// 客户端缓存仍然有效时直接返回304，无需读取文件
+	etag := imageETag(file, size)
+	if checkNotModified(c, etag, file.CreatedAt) {
 		return
 	}
-	defer resp.Body.Close()
 
-	// 设置响应头
-	contentType := resp.Header.Get("Content-Type")
+	// 优先从本地缓存读取
+	cacheKey := fmt.Sprintf("original/%s/%s", file.TelegramFileID, size)
+	cache := service.ImageCache()
+	if cache != nil {
+		if body, err := cache.Open(cacheKey); err == nil {
+			defer body.Close()
+			serveImageContent(c, file, src, etag, body)
+			return
+		}
+	}
+
+	// 类型未知的旧文件需要根据内容判断类型，下载完整内容后再返回
+	if src.contentType == "" {
+		serveDownloadedImage(c, st, file, src, etag, cacheKey)
+		return
+	}
+
+	// 未命中缓存时，HEAD和Range请求根据文件记录的类型和大小响应，Range请求只读取需要的部分
+	setImageHeaders(c, file, src.contentType, etag)
+	c.Header("Last-Modified", file.CreatedAt.UTC().Format(http.TimeFormat))
+	if src.size > 0 {
+		c.Header("Accept-Ranges", "bytes")
+	}
+	if c.Request.Method == http.MethodHead {
+		if src.size > 0 {
+			c.Header("Content-Length", strconv.FormatInt(src.size, 10))
+		}
+		c.Status(http.StatusOK)
+		return
+	}
+	if src.size > 0 && c.GetHeader("Range") != "" && ifRangeMatches(c, etag, file.CreatedAt) {
+		if serveImageRange(c, st, src) {
+			return
+		}
+	}
+
+	// 完整内容边从存储后端读取边返回，同时写入缓存
+	if src.size > 0 {
+		c.Header("Content-Length", strconv.FormatInt(src.size, 10))
+	}
+	fill := func(w io.Writer) error {
+		body, err := src.open(c.Request.Context(), st, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.Copy(w, body)
+		return err
+	}
+	out := &responseWriter{c: c}
+	if cache != nil {
+		var body service.ReadSeekCloser
+		body, err = cache.Stream(cacheKey, src.size, out, fill)
+		if body != nil {
+			// 其他请求刚好完成了回源
+			defer body.Close()
+			c.Writer.Header().Del("Content-Length")
+			http.ServeContent(c.Writer, c.Request, "", file.CreatedAt, body)
+			return
+		}
+	} else {
+		err = fill(out)
+	}
+	if err != nil {
+		if out.written {
+			// 已开始返回内容，无法再返回错误信息，中断响应
+			log.Printf("返回图片失败 - 文件ID: %s, 错误: %v", file.TelegramFileID, err)
+			c.Abort()
+			return
+		}
+		clearImageHeaders(c)
+		if errors.Is(err, service.ErrObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+	}
+}
+
+// responseWriter 记录是否已向客户端写入内容
+type responseWriter struct {
+	c       *gin.Context
+	written bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.c.Writer.Write(p)
+}
+
+// serveImageContent 返回本地的图片内容，由ServeContent处理条件请求和Range请求
+// 原图使用上传时识别的类型，类型未知时根据文件内容判断
+func serveImageContent(c *gin.Context, file *model.File, src *imageSource, etag string, body service.ReadSeekCloser) {
+	contentType := src.contentType
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(body, head)
+		contentType = http.DetectContentType(head[:n])
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取图片失败: %v", err)})
+			return
+		}
+	}
+
+	setImageHeaders(c, file, contentType, etag)
+	http.ServeContent(c.Writer, c.Request, "", file.CreatedAt, body)
+}
+
+// serveDownloadedImage 下载完整内容并写入缓存后返回，用于需要根据内容判断类型的文件
+func serveDownloadedImage(c *gin.Context, st service.Storage, file *model.File, src *imageSource, etag, cacheKey string) {
+	fill := func(w io.Writer) error {
+		body, err := src.open(c.Request.Context(), st, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.Copy(w, body)
+		return err
+	}
+
+	var body service.ReadSeekCloser
+	var err error
+	if cache := service.ImageCache(); cache != nil {
+		body, err = cache.Fetch(cacheKey, fill)
+	} else {
+		// 缓存不可用时直接回源
+		body, err = service.FetchUncached(fill)
+	}
+	if err != nil {
+		if errors.Is(err, service.ErrObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+		return
+	}
+	defer body.Close()
+
+	serveImageContent(c, file, src, etag, body)
+}
+
+// setImageHeaders 设置图片的类型、缓存和显示方式
+func setImageHeaders(c *gin.Context, file *model.File, contentType, etag string) {
 	c.Header("Content-Type", contentType)
-	c.Header("Content-Length", resp.Header.Get("Content-Length"))
 	c.Header("Cache-Control", "public, max-age=31536000")
+	c.Header("ETag", etag)
 
 	// 根据内容类型设置不同的响应头
-	if strings.HasPrefix(contentType, "image/") {
-		// 如果是图片，设置为内联显示
+	if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") {
+		// 如果是图片或视频，设置为内联显示
 		c.Header("Content-Disposition", "inline")
 	} else {
 		// 如果不是图片，设置为附件下载
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"image_%s\"", telegramFileID))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"image_%s\"", file.TelegramFileID))
+	}
+}
+
+// clearImageHeaders 返回错误信息前清除已设置的图片响应头，避免错误响应被当作图片缓存
+func clearImageHeaders(c *gin.Context) {
+	for _, name := range []string{"Content-Type", "Content-Length", "Cache-Control", "ETag", "Content-Disposition", "Last-Modified", "Accept-Ranges"} {
+		c.Writer.Header().Del(name)
+	}
+}
+
+// ifRangeMatches 没有If-Range或其与当前内容一致时Range请求有效，否则应返回完整内容
+func ifRangeMatches(c *gin.Context, etag string, modTime time.Time) bool {
+	ir := c.GetHeader("If-Range")
+	if ir == "" || ir == etag {
+		return true
+	}
+	if t, err := http.ParseTime(ir); err == nil {
+		return !modTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// serveImageRange 按Range请求从存储后端读取对应的部分返回，只支持单个范围
+// 返回false时Range格式不支持（如多个范围），调用方应返回完整内容
+func serveImageRange(c *gin.Context, st service.Storage, src *imageSource) bool {
+	start, end, ok := parseRange(c.GetHeader("Range"), src.size)
+	if !ok {
+		return false
+	}
+	if start < 0 {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", src.size))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	body, err := src.open(c.Request.Context(), st, start, end-start)
+	if err != nil {
+		clearImageHeaders(c)
+		if errors.Is(err, service.ErrObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+			return true
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+		return true
+	}
+	defer body.Close()
+
+	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, src.size))
+	c.Header("Content-Length", strconv.FormatInt(end-start, 10))
+	c.Status(http.StatusPartialContent)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		log.Printf("返回图片失败: %v", err)
+		c.Abort()
+	}
+	return true
+}
+
+// parseRange 解析单个范围的Range请求头，返回[start, end)
+// 格式不支持时ok为false；范围超出内容大小时start为-1
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// 最后n个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 {
+			return -1, 0, true
+		}
+		return max(size-n, 0), size, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = size
+	if last != "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < start {
+			return 0, 0, false
+		}
+		end = min(n+1, size)
+	}
+	if start >= size {
+		return -1, 0, true
+	}
+	return start, end, true
+}
+
+// imageETag 根据文件MD5生成强ETag，缩略图和处理后的图片附加对应的参数
+func imageETag(file *model.File, suffixes ...string) string {
+	tag := file.MD5Hash
+	for _, suffix := range suffixes {
+		if suffix != "" {
+			tag += "-" + suffix
+		}
+	}
+	return `"` + tag + `"`
+}
+
+// checkNotModified 处理If-None-Match和If-Modified-Since，客户端缓存有效时返回304
+func checkNotModified(c *gin.Context, etag string, modTime time.Time) bool {
+	notModified := false
+
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				notModified = true
+				break
+			}
+		}
+	} else if ims := c.GetHeader("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil && !modTime.Truncate(time.Second).After(t) {
+			notModified = true
+		}
+	}
+
+	if !notModified {
+		return false
 	}
 
-	// 将图片内容写入响应
-	c.Status(http.StatusOK)
-	io.Copy(c.Writer, resp.Body)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=31536000")
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// parseTransformOptions 解析图片处理参数，未指定任何参数时返回nil
+func parseTransformOptions(c *gin.Context) (*service.TransformOptions, error) {
+	var opts service.TransformOptions
+	specified := false
+
+	intParam := func(name string) (int, error) {
+		value := c.Query(name)
+		if value == "" {
+			return 0, nil
+		}
+		specified = true
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s格式错误", name)
+		}
+		return n, nil
+	}
+
+	var err error
+	if opts.Width, err = intParam("w"); err != nil {
+		return nil, err
+	}
+	if opts.Height, err = intParam("h"); err != nil {
+		return nil, err
+	}
+	if opts.Quality, err = intParam("q"); err != nil {
+		return nil, err
+	}
+	if c.Query("q") != "" && (opts.Quality < 1 || opts.Quality > 100) {
+		return nil, fmt.Errorf("q必须在1到100之间")
+	}
+
+	if fit := c.Query("fit"); fit != "" {
+		specified = true
+		opts.Fit = fit
+	}
+
+	if format := strings.ToLower(c.Query("format")); format != "" {
+		specified = true
+		if format == "jpg" {
+			format = service.FormatJPEG
+		}
+		opts.Format = format
+	}
+
+	// 裁剪区域格式: x,y,宽,高
+	if crop := c.Query("crop"); crop != "" {
+		specified = true
+		parts := strings.Split(crop, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("crop格式应为x,y,宽,高")
+		}
+		var values [4]int
+		for i, part := range parts {
+			if values[i], err = strconv.Atoi(strings.TrimSpace(part)); err != nil {
+				return nil, fmt.Errorf("crop格式应为x,y,宽,高")
+			}
+		}
+		if values[2] <= 0 || values[3] <= 0 {
+			return nil, fmt.Errorf("裁剪区域的宽高必须大于0")
+		}
+		opts.Crop = image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+	}
+
+	if !specified {
+		return nil, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// serveTransformedImage 返回处理后的图片，处理结果写入磁盘缓存，相同参数的请求不再访问存储后端
+func serveTransformedImage(c *gin.Context, st service.Storage, file *model.File, thumbnail string, opts service.TransformOptions) {
+	cache := service.ImageCache()
+	cacheKey := fmt.Sprintf("transform/%s/%s/%s", file.TelegramFileID, thumbnail, opts.CacheKey())
+
+	sum := sha256.Sum256([]byte(cacheKey))
+	etag := imageETag(file, hex.EncodeToString(sum[:8]))
+	if checkNotModified(c, etag, file.CreatedAt) {
+		return
+	}
+
+	var data []byte
+	if cache != nil {
+		if f, err := cache.Open(cacheKey); err == nil {
+			data, err = io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				data = nil
+			}
+		}
+	}
+
+	if data == nil {
+		// 限制同时处理的图片数，解码大图占用的内存和CPU较多
+		release, err := service.AcquireTransformSlot(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "图片处理繁忙，请稍后重试"})
+			return
+		}
+		defer release()
+
+		src, err := resolveImageSource(file, thumbnail)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+			return
+		}
+		body, err := src.open(c.Request.Context(), st, 0, -1)
+		if err != nil {
+			if errors.Is(err, service.ErrObjectNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片失败: %v", err)})
+			return
+		}
+		defer body.Close()
+
+		data, _, err = service.TransformImage(body, opts)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("处理图片失败: %v", err)})
+			return
+		}
+
+		if cache != nil {
+			if err := cache.Put(cacheKey, data); err != nil {
+				log.Printf("写入图片缓存失败: %v", err)
+			}
+		}
+	}
+
+	c.Header("Content-Type", http.DetectContentType(data))
+	c.Header("Cache-Control", "public, max-age=31536000")
+	c.Header("Content-Disposition", "inline")
+	c.Header("ETag", etag)
+	http.ServeContent(c.Writer, c.Request, "", file.CreatedAt, bytes.NewReader(data))
+}
+
+// thumbnailSizes 缩略图尺寸对应的最长边像素
+var thumbnailSizes = map[string]int{
+	"small":  320,
+	"medium": 800,
+	"large":  1280,
+}
+
+// thumbnailURL 缩略图地址，文件没有尺寸版本也无法缩放时（如视频和RAW）返回空
+func thumbnailURL(c *gin.Context, file *model.File) string {
+	if !service.CanTransform(file.MimeType) && file.UploadMode != service.UploadModePhoto {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s/proxy/image/%s?size=small", getScheme(c), c.Request.Host, file.TelegramFileID)
+}
+
+// pickVariant 选择最长边不超过maxSide的最大尺寸版本，都超过时选择最小的版本
+func pickVariant(variants []model.FileVariant, maxSide int) *model.FileVariant {
+	var picked *model.FileVariant
+	for i := range variants {
+		v := &variants[i]
+		side := v.Width
+		if v.Height > side {
+			side = v.Height
+		}
+		if side <= maxSide || picked == nil {
+			picked = v
+		}
+		if side >= maxSide {
+			break
+		}
+	}
+	return picked
+}
+
+// imageSource 代理访问的内容，为原图或某一尺寸版本
+type imageSource struct {
+	key    string
+	chunks []service.Chunk
+	// variant 是否为尺寸版本
+	variant bool
+	// contentType 为空时需要根据内容判断
+	contentType string
+	// size 为0时未知
+	size int64
+}
+
+// resolveImageSource 确定要读取的内容，thumbnail不为空且文件存在对应尺寸版本时为该版本，否则为原图
+func resolveImageSource(file *model.File, thumbnail string) (*imageSource, error) {
+	if thumbnail != "" {
+		variants, err := model.GetFileVariants(file.ID)
+		if err != nil {
+			return nil, err
+		}
+		if v := pickVariant(variants, thumbnailSizes[thumbnail]); v != nil {
+			// Telegram生成的尺寸版本都是JPEG
+			return &imageSource{key: v.TelegramFileID, variant: true, contentType: "image/jpeg", size: v.FileSize}, nil
+		}
+	}
+
+	chunks, err := model.GetFileChunks(file.ID)
+	if err != nil {
+		return nil, err
+	}
+	src := &imageSource{key: file.TelegramFileID, contentType: file.MimeType, size: file.Size}
+	if len(chunks) > 0 {
+		src.size = 0
+		for _, chunk := range chunks {
+			src.chunks = append(src.chunks, service.Chunk{Key: chunk.TelegramFileID, Size: chunk.Size})
+			src.size += chunk.Size
+		}
+	}
+	return src, nil
+}
+
+// open 读取从offset开始的length字节，length小于0时读取到末尾；分块存储的文件按顺序拼接为一个完整的流
+func (s *imageSource) open(ctx context.Context, st service.Storage, offset, length int64) (io.ReadCloser, error) {
+	if len(s.chunks) > 0 {
+		return service.NewChunkRangeReader(ctx, st, s.chunks, offset, length), nil
+	}
+	return service.GetRange(ctx, st, s.key, offset, length)
+}
+
+// maxUploadSize 单个文件的上传大小限制
+func maxUploadSize() int64 {
+	size := viper.GetInt64("upload.max_size_mb")
+	if size <= 0 {
+		size = 20
+	}
+	return size * 1024 * 1024
 }
 
 // adminListImages 管理员获取所有图片
@@ -399,15 +1370,25 @@ func adminListImages(c *gin.Context) {
 		if err != nil {
 			continue
 		}
-		imageList = append(imageList, gin.H{
-			"id":         img.ID,
-			"file_id":    file.TelegramFileID,
-			"user_id":    img.UserID,
-			"upload_ip":  img.UploadIP,
-			"md5_hash":   file.MD5Hash,
-			"created_at": img.CreatedAt,
-			"proxy_url":  fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, file.TelegramFileID),
-		})
+		item := gin.H{
+			"id":                img.ID,
+			"file_id":           file.TelegramFileID,
+			"user_id":           img.UserID,
+			"upload_ip":         img.UploadIP,
+			"md5_hash":          file.MD5Hash,
+			"sha256_hash":       file.SHA256Hash,
+			"mime_type":         file.MimeType,
+			"width":             file.Width,
+			"height":            file.Height,
+			"size":              file.Size,
+			"original_filename": file.OriginalFilename,
+			"created_at":        img.CreatedAt,
+			"proxy_url":         fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, file.TelegramFileID),
+		}
+		if url := thumbnailURL(c, file); url != "" {
+			item["thumbnail_url"] = url
+		}
+		imageList = append(imageList, item)
 	}
 
 	// 返回结果
@@ -427,9 +1408,36 @@ func getStats(c *gin.Context) {
 		return
 	}
 
+	// 图片缓存和getFile结果缓存命中情况
+	if cache := service.ImageCache(); cache != nil {
+		stats["cache"] = cache.Stats()
+	}
+	stats["file_path_cache"] = service.TelegramFilePathStats()
+	stats["telegram_bots"] = service.TelegramBotStatus()
+
 	c.JSON(http.StatusOK, stats)
 }
 
+// adminStartFileBackfill 启动补全旧文件尺寸、大小和类型的后台任务
+func adminStartFileBackfill(c *gin.Context) {
+	status, err := service.StartFileInfoBackfill()
+	if errors.Is(err, service.ErrBackfillRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": status})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, status)
+}
+
+// adminGetFileBackfill 获取补全任务的进度
+func adminGetFileBackfill(c *gin.Context) {
+	c.JSON(http.StatusOK, service.FileInfoBackfillProgress())
+}
+
 // getImage 获取图片详情
 func getImage(c *gin.Context) {
 	// 获取图片ID
@@ -468,14 +1476,40 @@ func getImage(c *gin.Context) {
 		return
 	}
 
+	// 获取图片元数据，元数据功能上线前上传的图片没有元数据
+	metadata, err := model.GetImageMetadataByImageIDs([]uint{image.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片元数据失败: %v", err)})
+		return
+	}
+	var imageMetadata interface{}
+	if m, ok := metadata[image.ID]; ok {
+		imageMetadata = m
+	}
+
 	// 返回图片信息
-	c.JSON(http.StatusOK, gin.H{
-		"id":         image.ID,
-		"file_id":    file.TelegramFileID,
-		"user_id":    image.UserID,
-		"upload_ip":  image.UploadIP,
-		"md5_hash":   file.MD5Hash,
-		"created_at": image.CreatedAt,
-		"proxy_url":  fmt.Sprintf("%s://%s/proxy/image/%s", c.Request.URL.Scheme, c.Request.Host, file.TelegramFileID),
-	})
+	response := gin.H{
+		"id":                image.ID,
+		"file_id":           file.TelegramFileID,
+		"user_id":           image.UserID,
+		"upload_ip":         image.UploadIP,
+		"md5_hash":          file.MD5Hash,
+		"sha256_hash":       file.SHA256Hash,
+		"phash":             file.PHash,
+		"mime_type":         file.MimeType,
+		"width":             file.Width,
+		"height":            file.Height,
+		"size":              file.Size,
+		"original_filename": file.OriginalFilename,
+		"upload_mode":       file.UploadMode,
+		"storage":           file.Storage,
+		"created_at":        image.CreatedAt,
+		"updated_at":        image.UpdatedAt,
+		"proxy_url":         fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, file.TelegramFileID),
+		"metadata":          imageMetadata,
+	}
+	if url := thumbnailURL(c, file); url != "" {
+		response["thumbnail_url"] = url
+	}
+	c.JSON(http.StatusOK, response)
 }
@@ -23,21 +23,39 @@ func RegisterRoutes(r *gin.Engine) {
 	image.Use(middleware.JWTAuth())
 	{
 		image.POST("/upload", uploadImage)
+		image.POST("/upload/batch", uploadImageBatch)
+		image.POST("/upload/url", uploadImageFromURL)
+		image.GET("/jobs/:id", getUploadJob)
+		image.POST("/tus/", tusCreate)
+		image.HEAD("/tus/:id", tusHead)
+		image.PATCH("/tus/:id", tusPatch)
+		image.DELETE("/tus/:id", tusDelete)
 		image.GET("/list", listImages)
+		image.GET("/:id", getImage)
+		image.GET("/:id/similar", similarImages)
 		image.DELETE("/:id", deleteImage)
 	}
 
+	// tus协议发现请求不需要认证
+	v1.OPTIONS("/image/tus/", tusOptions)
+	v1.OPTIONS("/image/tus/:id", tusOptions)
+
 	// 管理员路由
 	admin := v1.Group("/admin")
 	admin.Use(middleware.JWTAuth(), middleware.AdminAuth())
 	{
 		admin.GET("/images", adminListImages)
+		admin.GET("/images/similar", adminSimilarImages)
 		admin.GET("/stats", getStats)
+		admin.GET("/jobs", adminListUploadJobs)
+		admin.POST("/files/backfill", adminStartFileBackfill)
+		admin.GET("/files/backfill", adminGetFileBackfill)
 	}
 
 	// 代理访问路由
 	proxy := r.Group("/proxy")
 	{
 		proxy.GET("/image/:file_id", proxyImage)
+		proxy.HEAD("/image/:file_id", proxyImage)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,209 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/telegram-photo/model"
+	"github.com/telegram-photo/service"
+)
+
+// parseSimilarDistance 读取distance参数，为判断相似的最大汉明距离
+func parseSimilarDistance(c *gin.Context) (int, error) {
+	distance, err := strconv.Atoi(c.DefaultQuery("distance", strconv.Itoa(service.DefaultSimilarDistance)))
+	if err != nil || distance < 0 || distance > service.MaxSimilarDistance {
+		return 0, fmt.Errorf("distance必须在0到%d之间", service.MaxSimilarDistance)
+	}
+	return distance, nil
+}
+
+// similarImages 查找用户图片中与指定图片视觉相似的图片，按汉明距离从小到大排列
+func similarImages(c *gin.Context) {
+	// 获取用户ID
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "图片ID格式错误"})
+		return
+	}
+
+	maxDistance, err := parseSimilarDistance(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	// 查询数据库
+	image, err := model.GetImageByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		return
+	}
+
+	// 检查权限
+	if image.UserID != userID && !c.GetBool("is_admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该图片"})
+		return
+	}
+
+	if image.File.PHash == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "该图片尚未计算感知哈希，无法查找相似图片"})
+		return
+	}
+
+	// 在图片所有者的图片中查找
+	hashes, err := model.GetImageHashesByUserID(image.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片列表失败: %v", err)})
+		return
+	}
+
+	type match struct {
+		hash     model.ImageHash
+		distance int
+	}
+	var matches []match
+	for _, h := range hashes {
+		if h.ImageID == image.ID {
+			continue
+		}
+		distance, err := service.HashDistance(image.File.PHash, h.PHash)
+		if err != nil || distance > maxDistance {
+			continue
+		}
+		matches = append(matches, match{hash: h, distance: distance})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].hash.CreatedAt.After(matches[j].hash.CreatedAt)
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	result := make([]gin.H, 0, len(matches))
+	for _, m := range matches {
+		result = append(result, gin.H{
+			"id":            m.hash.ImageID,
+			"file_id":       m.hash.TelegramFileID,
+			"distance":      m.distance,
+			"created_at":    m.hash.CreatedAt,
+			"proxy_url":     fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, m.hash.TelegramFileID),
+			"thumbnail_url": fmt.Sprintf("%s://%s/proxy/image/%s?size=small", getScheme(c), c.Request.Host, m.hash.TelegramFileID),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       image.ID,
+		"phash":    image.File.PHash,
+		"distance": maxDistance,
+		"images":   result,
+	})
+}
+
+// adminSimilarImages 管理员查看所有文件中视觉相似的分组，按组内文件数从多到少排列
+func adminSimilarImages(c *gin.Context) {
+	maxDistance, err := parseSimilarDistance(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	// 分组需要比较文件两两之间的距离，文件过多时只检查最新上传的文件
+	maxFiles := viper.GetInt("image.similar_max_files")
+	if maxFiles <= 0 {
+		maxFiles = 20000
+	}
+	files, err := model.GetFilesWithPHash(maxFiles + 1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取文件列表失败: %v", err)})
+		return
+	}
+	truncated := len(files) > maxFiles
+	if truncated {
+		files = files[:maxFiles]
+	}
+
+	hashes := make([]string, len(files))
+	for i, file := range files {
+		hashes[i] = file.PHash
+	}
+	clusters := service.ClusterSimilar(hashes, maxDistance)
+	total := len(clusters)
+	if len(clusters) > limit {
+		clusters = clusters[:limit]
+	}
+
+	// 批量查询各文件对应的图片
+	var fileIDs []uint
+	for _, cluster := range clusters {
+		for _, i := range cluster {
+			fileIDs = append(fileIDs, files[i].ID)
+		}
+	}
+	images, err := model.GetImagesByFileIDs(fileIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取图片列表失败: %v", err)})
+		return
+	}
+
+	result := make([]gin.H, 0, len(clusters))
+	for _, cluster := range clusters {
+		items := make([]gin.H, 0, len(cluster))
+		for _, i := range cluster {
+			file := files[i]
+
+			owners := make([]gin.H, 0, len(images[file.ID]))
+			for _, img := range images[file.ID] {
+				owners = append(owners, gin.H{
+					"id":         img.ID,
+					"user_id":    img.UserID,
+					"created_at": img.CreatedAt,
+				})
+			}
+
+			items = append(items, gin.H{
+				"file_id":       file.TelegramFileID,
+				"phash":         file.PHash,
+				"width":         file.Width,
+				"height":        file.Height,
+				"size":          file.Size,
+				"created_at":    file.CreatedAt,
+				"proxy_url":     fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, file.TelegramFileID),
+				"thumbnail_url": fmt.Sprintf("%s://%s/proxy/image/%s?size=small", getScheme(c), c.Request.Host, file.TelegramFileID),
+				"images":        owners,
+			})
+		}
+		result = append(result, gin.H{"count": len(items), "files": items})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusters":      result,
+		"total":         total,
+		"distance":      maxDistance,
+		"files_checked": len(files),
+		"truncated":     truncated,
+	})
+}
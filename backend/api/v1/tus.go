@@ -0,0 +1,274 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/telegram-photo/service"
+)
+
+const (
+	// tusVersion 支持的tus协议版本
+	tusVersion = "1.0.0"
+	// tusExtensions 支持的tus协议扩展
+	tusExtensions = "creation,termination,expiration"
+)
+
+// tusHeaders 设置所有tus响应都需要的协议头
+func tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+}
+
+// tusCheckVersion 检查客户端使用的协议版本，不支持时返回412
+func tusCheckVersion(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "不支持的tus协议版本"})
+		return false
+	}
+	return true
+}
+
+// tusUploadURL 上传的地址
+func tusUploadURL(c *gin.Context, id string) string {
+	return fmt.Sprintf("%s://%s/api/v1/image/tus/%s", getScheme(c), c.Request.Host, id)
+}
+
+// tusOptions 返回服务器支持的tus协议版本和扩展，不需要认证
+func tusOptions(c *gin.Context) {
+	tusHeaders(c)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(maxUploadSize(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// tusCreate 创建断点续传上传
+// 文件名、类型和上传模式通过Upload-Metadata的filename、filetype和mode传递
+func tusCreate(c *gin.Context) {
+	tusHeaders(c)
+	if !tusCheckVersion(c) {
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证"})
+		return
+	}
+
+	store, err := service.TusUploads()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length格式错误"})
+		return
+	}
+	if maxSize := maxUploadSize(); length > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("图片大小不能超过%dMB", maxSize/1024/1024)})
+		return
+	}
+
+	rawMetadata := c.GetHeader("Upload-Metadata")
+	metadata, err := service.ParseTusMetadata(rawMetadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if mode := metadata["mode"]; mode != "" && !service.ValidUploadMode(mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "上传模式只能是photo或document"})
+		return
+	}
+
+	upload, err := store.Create(userID, getRealIP(c), length, rawMetadata)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("创建上传失败: %v", err)})
+		return
+	}
+
+	c.Header("Location", tusUploadURL(c, upload.ID))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// tusUpload 获取当前用户的上传，不存在或不属于该用户时返回404
+func tusUpload(c *gin.Context) (*service.TusStore, *service.TusUpload, bool) {
+	store, err := service.TusUploads()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	upload, err := store.Get(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, service.ErrTusUploadNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("获取上传失败: %v", err)})
+		}
+		return nil, nil, false
+	}
+
+	// 检查权限
+	if upload.UserID != c.GetString("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": service.ErrTusUploadNotFound.Error()})
+		return nil, nil, false
+	}
+
+	return store, upload, true
+}
+
+// tusHead 查询上传已接收的数据量，客户端据此从断点继续上传
+func tusHead(c *gin.Context) {
+	tusHeaders(c)
+	if !tusCheckVersion(c) {
+		return
+	}
+
+	_, upload, ok := tusUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.RawMeta != "" {
+		c.Header("Upload-Metadata", upload.RawMeta)
+	}
+	c.Status(http.StatusOK)
+}
+
+// tusPatch 从Upload-Offset处追加数据，接收完全部数据后保存为图片
+// 保存失败时保留已接收的数据，客户端可以在原偏移量发送空请求重试，文件不是允许的图片时删除上传并返回400
+func tusPatch(c *gin.Context) {
+	tusHeaders(c)
+	if !tusCheckVersion(c) {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type必须为application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset格式错误"})
+		return
+	}
+
+	store, upload, ok := tusUpload(c)
+	if !ok {
+		return
+	}
+
+	// 同一上传的追加和保存依次执行
+	unlock := store.Lock(upload.ID)
+	defer unlock()
+
+	upload, err = store.Append(upload.ID, offset, c.Request.Body)
+	if errors.Is(err, service.ErrTusOffsetMismatch) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrTusUploadNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if upload != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存上传数据失败: %v", err)})
+		return
+	}
+
+	if !upload.Complete() {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if err := tusFinish(c, store, upload); err != nil {
+		// 文件内容不是允许的图片时重试也无法成功，直接删除
+		if errors.Is(err, service.ErrInvalidImage) {
+			store.Delete(upload.ID)
+		}
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// tusFinish 将接收完成的上传保存为文件和图片记录，并删除本地数据
+// 通过X-File-ID和X-Proxy-URL响应头返回保存结果
+func tusFinish(c *gin.Context, store *service.TusStore, upload *service.TusUpload) error {
+	file, err := store.Open(upload.ID)
+	if err != nil {
+		return fmt.Errorf("读取上传数据失败: %w", err)
+	}
+	defer file.Close()
+
+	filename := upload.Metadata["filename"]
+	if filename == "" {
+		filename = upload.Metadata["name"]
+	}
+	mode := upload.Metadata["mode"]
+	if mode == "" {
+		mode = service.DefaultUploadMode()
+	}
+
+	start := time.Now()
+	result, err := service.StoreUpload(c.Request.Context(), file, service.UploadOptions{
+		UserID:      upload.UserID,
+		UploadIP:    upload.UploadIP,
+		Filename:    filename,
+		ContentType: upload.Metadata["filetype"],
+		Mode:        mode,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("断点续传上传完成 - 上传ID: %s, 大小: %d, 耗时: %s", upload.ID, upload.Length, time.Since(start))
+
+	if err := store.Delete(upload.ID); err != nil {
+		log.Printf("删除断点续传数据失败 - 上传ID: %s, 错误: %v", upload.ID, err)
+	}
+
+	c.Header("X-File-ID", result.File.TelegramFileID)
+	c.Header("X-Proxy-URL", fmt.Sprintf("%s://%s/proxy/image/%s", getScheme(c), c.Request.Host, result.File.TelegramFileID))
+	return nil
+}
+
+// tusDelete 终止上传并删除已接收的数据
+func tusDelete(c *gin.Context) {
+	tusHeaders(c)
+	if !tusCheckVersion(c) {
+		return
+	}
+
+	store, upload, ok := tusUpload(c)
+	if !ok {
+		return
+	}
+
+	unlock := store.Lock(upload.ID)
+	defer unlock()
+
+	if err := store.Delete(upload.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("删除上传失败: %v", err)})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,220 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/telegram-photo/service"
+)
+
+// newTusTestRouter 注册tus路由，用户ID通过X-Test-User请求头指定
+func newTusTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	viper.Set("upload.tus_dir", t.TempDir())
+	if err := service.InitTusStore(); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	image := router.Group("/api/v1/image", func(c *gin.Context) {
+		c.Set("user_id", c.GetHeader("X-Test-User"))
+	})
+	image.POST("/tus/", tusCreate)
+	image.HEAD("/tus/:id", tusHead)
+	image.PATCH("/tus/:id", tusPatch)
+	image.DELETE("/tus/:id", tusDelete)
+	router.OPTIONS("/api/v1/image/tus/", tusOptions)
+	return router
+}
+
+// tusStep 一次tus请求及期望的响应
+type tusStep struct {
+	name    string
+	method  string
+	user    string
+	headers map[string]string
+	body    string
+	status  int
+	// want 期望的响应头
+	want map[string]string
+}
+
+func TestTusProtocol(t *testing.T) {
+	router := newTusTestRouter(t)
+
+	// 先创建上传，之后的请求发送到返回的Location
+	create := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/image/tus/", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", "10")
+	req.Header.Set("Upload-Metadata", "filename dGVzdC5qcGc=,mode ZG9jdW1lbnQ=")
+	req.Header.Set("X-Test-User", "alice")
+	router.ServeHTTP(create, req)
+	if create.Code != http.StatusCreated {
+		t.Fatalf("create: status %d, body %s", create.Code, create.Body)
+	}
+	location := create.Header().Get("Location")
+	path := location[strings.Index(location, "/api/"):]
+	if create.Header().Get("Tus-Resumable") != tusVersion || create.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("create: missing tus headers %v", create.Header())
+	}
+
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream"}
+	withOffset := func(offset string) map[string]string {
+		return map[string]string{"Content-Type": patch["Content-Type"], "Upload-Offset": offset}
+	}
+
+	steps := []tusStep{
+		{
+			name: "options", method: http.MethodOptions, status: http.StatusNoContent,
+			want: map[string]string{"Tus-Version": tusVersion, "Tus-Extension": tusExtensions},
+		},
+		{
+			name: "head without version", method: http.MethodHead, user: "alice", status: http.StatusPreconditionFailed,
+			headers: map[string]string{"Tus-Resumable": ""}, want: map[string]string{"Tus-Version": tusVersion},
+		},
+		{
+			name: "head new upload", method: http.MethodHead, user: "alice", status: http.StatusOK,
+			want: map[string]string{"Upload-Offset": "0", "Upload-Length": "10", "Cache-Control": "no-store", "Upload-Metadata": "filename dGVzdC5qcGc=,mode ZG9jdW1lbnQ="},
+		},
+		{name: "head other user", method: http.MethodHead, user: "bob", status: http.StatusNotFound},
+		{name: "patch wrong content type", method: http.MethodPatch, user: "alice", headers: map[string]string{"Upload-Offset": "0"}, body: "abcd", status: http.StatusUnsupportedMediaType},
+		{name: "patch without offset", method: http.MethodPatch, user: "alice", headers: patch, body: "abcd", status: http.StatusBadRequest},
+		{
+			name: "patch first part", method: http.MethodPatch, user: "alice", headers: withOffset("0"), body: "abcd", status: http.StatusNoContent,
+			want: map[string]string{"Upload-Offset": "4"},
+		},
+		{name: "patch stale offset", method: http.MethodPatch, user: "alice", headers: withOffset("2"), body: "cdef", status: http.StatusConflict},
+		{name: "patch other user", method: http.MethodPatch, user: "bob", headers: withOffset("4"), body: "efgh", status: http.StatusNotFound},
+		{
+			name: "head after patch", method: http.MethodHead, user: "alice", status: http.StatusOK,
+			want: map[string]string{"Upload-Offset": "4", "Upload-Length": "10"},
+		},
+		{
+			name: "patch empty body", method: http.MethodPatch, user: "alice", headers: withOffset("4"), status: http.StatusNoContent,
+			want: map[string]string{"Upload-Offset": "4"},
+		},
+		// 超出Upload-Length的数据被丢弃，接收完成后内容不是图片，删除上传
+		{
+			name: "patch past length", method: http.MethodPatch, user: "alice", headers: withOffset("4"), body: "efghijklmn", status: http.StatusBadRequest,
+			want: map[string]string{"Upload-Offset": "10"},
+		},
+		{name: "head after invalid image", method: http.MethodHead, user: "alice", status: http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		url := path
+		if step.method == http.MethodOptions {
+			url = "/api/v1/image/tus/"
+		}
+		req := httptest.NewRequest(step.method, url, strings.NewReader(step.body))
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("X-Test-User", step.user)
+		for name, value := range step.headers {
+			if value == "" {
+				req.Header.Del(name)
+			} else {
+				req.Header.Set(name, value)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != step.status {
+			t.Fatalf("%s: status %d, want %d, body %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if got := rec.Header().Get("Tus-Resumable"); got != tusVersion {
+			t.Fatalf("%s: Tus-Resumable = %q", step.name, got)
+		}
+		for name, want := range step.want {
+			if got := rec.Header().Get(name); got != want {
+				t.Fatalf("%s: %s = %q, want %q", step.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestTusTermination(t *testing.T) {
+	router := newTusTestRouter(t)
+
+	tests := []struct {
+		name   string
+		user   string
+		status int
+		// headStatus 终止后查询上传的状态码
+		headStatus int
+	}{
+		{"other user", "bob", http.StatusNotFound, http.StatusOK},
+		{"owner", "alice", http.StatusNoContent, http.StatusNotFound},
+	}
+
+	create := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/image/tus/", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", "4")
+	req.Header.Set("X-Test-User", "alice")
+	router.ServeHTTP(create, req)
+	location := create.Header().Get("Location")
+	path := location[strings.Index(location, "/api/"):]
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, step := range []struct {
+				method, user string
+				status       int
+			}{
+				{http.MethodDelete, tc.user, tc.status},
+				{http.MethodHead, "alice", tc.headStatus},
+			} {
+				req := httptest.NewRequest(step.method, path, nil)
+				req.Header.Set("Tus-Resumable", tusVersion)
+				req.Header.Set("X-Test-User", step.user)
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				if rec.Code != step.status {
+					t.Fatalf("%s: status %d, want %d", step.method, rec.Code, step.status)
+				}
+			}
+		})
+	}
+}
+
+func TestTusCreateValidation(t *testing.T) {
+	router := newTusTestRouter(t)
+	viper.Set("upload.max_size_mb", 1)
+	t.Cleanup(func() { viper.Set("upload.max_size_mb", nil) })
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"missing length", map[string]string{}, http.StatusBadRequest},
+		{"zero length", map[string]string{"Upload-Length": "0"}, http.StatusBadRequest},
+		{"too large", map[string]string{"Upload-Length": "2097152"}, http.StatusRequestEntityTooLarge},
+		{"bad metadata", map[string]string{"Upload-Length": "4", "Upload-Metadata": "filename not-base64!"}, http.StatusBadRequest},
+		{"bad mode", map[string]string{"Upload-Length": "4", "Upload-Metadata": "mode dmlkZW8="}, http.StatusBadRequest},
+		{"old version", map[string]string{"Upload-Length": "4", "Tus-Resumable": "0.2.2"}, http.StatusPreconditionFailed},
+		{"valid", map[string]string{"Upload-Length": "4"}, http.StatusCreated},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/image/tus/", nil)
+			req.Header.Set("Tus-Resumable", tusVersion)
+			req.Header.Set("X-Test-User", "alice")
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d, body %s", rec.Code, tc.status, rec.Body)
+			}
+		})
+	}
+}
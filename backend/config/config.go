@@ -20,6 +20,9 @@ func Init() error {
 	// 尝试从环境变量读取配置
 	viper.AutomaticEnv()
 
+	// 设置可选配置项的默认值
+	setDefaults()
+
 	// 读取配置文件
 	if err := viper.ReadInConfig(); err != nil {
 		// 如果配置文件不存在，创建默认配置文件
@@ -32,6 +35,45 @@ func Init() error {
 	return nil
 }
 
+// setDefaults 设置可选配置项的默认值，配置文件中未填写时生效
+func setDefaults() {
+	viper.SetDefault("upload.max_size_mb", 200)
+	viper.SetDefault("upload.temp_dir", "")
+	viper.SetDefault("upload.allowed_formats", []string{"jpeg", "png", "gif", "webp"})
+	viper.SetDefault("upload.allow_trailing_data", false)
+	viper.SetDefault("upload.strip_trailing_data", true)
+	viper.SetDefault("upload.passthrough_formats", []string{})
+	viper.SetDefault("upload.exif_policy", "strip_gps")
+	viper.SetDefault("upload.spool_dir", "./spool")
+	viper.SetDefault("upload.spool_shared", false)
+	viper.SetDefault("upload.node_id", "")
+	viper.SetDefault("upload.workers", 4)
+	viper.SetDefault("upload.job_max_attempts", 3)
+	viper.SetDefault("upload.batch_max_files", 50)
+	viper.SetDefault("upload.batch_max_total_mb", 500)
+	viper.SetDefault("upload.batch_concurrency", 4)
+	viper.SetDefault("upload.url_timeout", "30s")
+	viper.SetDefault("upload.url_allow_private", false)
+	viper.SetDefault("upload.tus_dir", "./spool/tus")
+	viper.SetDefault("upload.tus_expire", "24h")
+	viper.SetDefault("telegram.api_url", "https://api.telegram.org")
+	viper.SetDefault("telegram.local_mode", false)
+	viper.SetDefault("telegram.upload_mode", "photo")
+	viper.SetDefault("telegram.file_path_ttl", "50m")
+	viper.SetDefault("telegram.bot_selection", "round_robin")
+	viper.SetDefault("telegram.timeout", "60s")
+	viper.SetDefault("telegram.max_retries", 3)
+	viper.SetDefault("telegram.max_retry_after", "60s")
+	viper.SetDefault("storage.driver", "telegram")
+	viper.SetDefault("storage.local.path", "./uploads")
+	viper.SetDefault("storage.s3.region", "us-east-1")
+	viper.SetDefault("storage.s3.path_style", false)
+	viper.SetDefault("cache.dir", "./cache")
+	viper.SetDefault("cache.max_size_mb", 1024)
+	viper.SetDefault("image.transform_concurrency", 4)
+	viper.SetDefault("image.similar_max_files", 20000)
+}
+
 // createDefaultConfig 创建默认配置文件
 func createDefaultConfig(configPath string) error {
 	// 设置默认值
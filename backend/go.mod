@@ -1,11 +1,14 @@
 module github.com/telegram-photo
 
-go 1.21.0
+go 1.22.2
 
 require (
+	github.com/HugoSmits86/nativewebp v1.2.1
 	github.com/gin-gonic/gin v1.10.1
 	github.com/golang-jwt/jwt/v4 v4.3.0
 	github.com/spf13/viper v1.20.1
+	golang.org/x/image v0.24.0
+	golang.org/x/net v0.33.0
 	gorm.io/driver/mysql v1.6.0
 	gorm.io/gorm v1.30.3
 )
@@ -46,9 +49,8 @@ require (
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/arch v0.8.0 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
-	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 	google.golang.org/protobuf v1.36.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
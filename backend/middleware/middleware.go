@@ -18,13 +18,14 @@ func Cors() gin.HandlerFunc {
 		origin := c.Request.Header.Get("Origin")
 		if origin != "" {
 			c.Header("Access-Control-Allow-Origin", origin)
-			c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, UPDATE")
-			c.Header("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization")
-			c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers")
+			c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, UPDATE, PATCH, HEAD")
+			c.Header("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization, Range, If-None-Match, If-Modified-Since, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Defer-Length")
+			c.Header("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires, X-File-ID, X-Proxy-URL")
 			c.Header("Access-Control-Allow-Credentials", "true")
 		}
 
-		if method == "OPTIONS" {
+		// 跨域预检请求和没有对应路由的OPTIONS请求直接返回，其余交给路由处理（如tus协议发现）
+		if method == "OPTIONS" && (c.GetHeader("Access-Control-Request-Method") != "" || c.FullPath() == "") {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
@@ -101,8 +102,9 @@ func JWTAuth() gin.HandlerFunc {
 			return
 		}
 
-		// 将用户信息存储到上下文中
+		// 将用户信息存储到上下文中，管理员可以查看其他用户的图片
 		c.Set("user_id", claims.UserID)
+		c.Set("is_admin", IsAdmin(claims.UserID))
 		c.Next()
 	}
 }
@@ -117,27 +119,27 @@ func AdminAuth() gin.HandlerFunc {
 			return
 		}
 
-		// 从配置中获取管理员ID列表
-		adminIDs := viper.GetStringSlice("admin.user_ids")
-		isAdmin := false
-
-		for _, adminID := range adminIDs {
-			if userID == adminID {
-				isAdmin = true
-				break
-			}
-		}
-
-		if !isAdmin {
+		if !IsAdmin(userID) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "无管理员权限"})
 			c.Abort()
 			return
 		}
 
+		c.Set("is_admin", true)
 		c.Next()
 	}
 }
 
+// IsAdmin 用户是否在配置的管理员ID列表中
+func IsAdmin(userID string) bool {
+	for _, adminID := range viper.GetStringSlice("admin.user_ids") {
+		if userID == adminID {
+			return true
+		}
+	}
+	return false
+}
+
 // Claims JWT声明结构
 type Claims struct {
 	UserID string `json:"user_id"`
@@ -190,4 +190,87 @@ func migrateToFileTable() error {
 
 	log.Println("数据迁移完成!")
 	return nil
-}
\ No newline at end of file
+}
+
+// dropMD5UniqueIndex 去重改为按SHA-256进行后，MD5不再要求唯一
+// 删除旧版本创建的唯一索引，由AutoMigrate重新创建普通索引
+func dropMD5UniqueIndex() error {
+	var count int64
+	DB.Table("information_schema.statistics").Where("table_schema = DATABASE() AND table_name = 'files' AND index_name = 'idx_files_md5_hash' AND non_unique = 0").Count(&count)
+	if count == 0 {
+		return nil
+	}
+
+	log.Println("删除md5_hash唯一索引...")
+	if err := DB.Exec("ALTER TABLE files DROP INDEX idx_files_md5_hash;").Error; err != nil {
+		return fmt.Errorf("删除md5_hash唯一索引失败: %v", err)
+	}
+	return nil
+}
+
+// columnExists 表中是否存在该列
+func columnExists(table, column string) bool {
+	var count int64
+	DB.Table("information_schema.columns").Where("table_schema = DATABASE() AND table_name = ? AND column_name = ?", table, column).Count(&count)
+	return count > 0
+}
+
+// prepareSHA256UniqueIndex 在AutoMigrate创建(sha256_hash, upload_mode)唯一索引之前处理已有数据
+// 未填写的SHA-256改为NULL，唯一索引允许多个NULL；多个实例同时上传产生的重复文件只保留ID最小的一个，
+// 其余文件清空SHA-256并记录duplicate_of，记录本身保留，以免已有的图片链接失效
+func prepareSHA256UniqueIndex() error {
+	if !columnExists("files", "sha256_hash") {
+		return nil
+	}
+
+	var nullable string
+	DB.Table("information_schema.columns").Select("is_nullable").Where("table_schema = DATABASE() AND table_name = 'files' AND column_name = 'sha256_hash'").Scan(&nullable)
+	if nullable == "NO" {
+		if err := DB.Exec("ALTER TABLE files MODIFY sha256_hash varchar(64) NULL;").Error; err != nil {
+			return fmt.Errorf("修改sha256_hash列失败: %v", err)
+		}
+	}
+	if err := DB.Exec("UPDATE files SET sha256_hash = NULL WHERE sha256_hash = '';").Error; err != nil {
+		return fmt.Errorf("清空未填写的SHA-256失败: %v", err)
+	}
+
+	if !columnExists("files", "duplicate_of") {
+		if err := DB.Exec("ALTER TABLE files ADD COLUMN duplicate_of bigint unsigned NOT NULL DEFAULT 0;").Error; err != nil {
+			return fmt.Errorf("添加duplicate_of列失败: %v", err)
+		}
+	}
+
+	// upload_mode列由AutoMigrate添加，之前的文件都以photo模式上传
+	group := "sha256_hash"
+	if columnExists("files", "upload_mode") {
+		group = "sha256_hash, upload_mode"
+	}
+	var duplicates []struct {
+		SHA256Hash string
+		UploadMode string
+		KeepID     uint
+	}
+	if err := DB.Raw("SELECT " + group + ", MIN(id) AS keep_id FROM files WHERE sha256_hash IS NOT NULL GROUP BY " + group + " HAVING COUNT(*) > 1;").Scan(&duplicates).Error; err != nil {
+		return fmt.Errorf("查找重复文件失败: %v", err)
+	}
+	for _, d := range duplicates {
+		query := DB.Table("files").Where("sha256_hash = ? AND id <> ?", d.SHA256Hash, d.KeepID)
+		if d.UploadMode != "" {
+			query = query.Where("upload_mode = ?", d.UploadMode)
+		}
+		if err := query.Updates(map[string]interface{}{"sha256_hash": nil, "duplicate_of": d.KeepID}).Error; err != nil {
+			return fmt.Errorf("标记重复文件失败: %v", err)
+		}
+		log.Printf("SHA-256为%s的文件存在重复，保留文件ID: %d", d.SHA256Hash, d.KeepID)
+	}
+
+	// 唯一索引包含sha256_hash开头的查询，原来的普通索引不再需要
+	var hasOldIndex int64
+	DB.Table("information_schema.statistics").Where("table_schema = DATABASE() AND table_name = 'files' AND index_name = 'idx_files_sha256_hash'").Count(&hasOldIndex)
+	if hasOldIndex > 0 {
+		if err := DB.Exec("ALTER TABLE files DROP INDEX idx_files_sha256_hash;").Error; err != nil {
+			return fmt.Errorf("删除sha256_hash索引失败: %v", err)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,19 +12,29 @@ import (
 
 var DB *gorm.DB
 
+// ErrDuplicateFile 已存在相同SHA-256和上传模式的文件，通常为其他实例同时上传了相同内容
+var ErrDuplicateFile = errors.New("已存在相同内容的文件")
+
 // Init 初始化数据库连接
 func Init() error {
 	dsn := config.GetDSN()
 	var err error
 
 	// 连接数据库
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return fmt.Errorf("连接数据库失败: %w", err)
 	}
 
+	if err := dropMD5UniqueIndex(); err != nil {
+		return err
+	}
+	if err := prepareSHA256UniqueIndex(); err != nil {
+		return err
+	}
+
 	// 执行AutoMigrate
-	err = DB.AutoMigrate(&File{}, &Image{}, &User{})
+	err = DB.AutoMigrate(&File{}, &FileChunk{}, &FileVariant{}, &Image{}, &ImageMetadata{}, &User{}, &UploadJob{})
 	if err != nil {
 		return fmt.Errorf("迁移数据表失败: %w", err)
 	}
@@ -31,12 +42,48 @@ func Init() error {
 }
 
 // File 文件模型
+// 按SHA256Hash和UploadMode唯一，MD5Hash仅为兼容旧客户端保留；旧文件的SHA256Hash由启动后的迁移任务下载校验后填写，未填写时为NULL
+// DuplicateOf 内容与另一文件相同时为该文件的ID，不参与去重，保留记录以免已有链接失效
+// Width、Height和Size为存储的原图的尺寸和字节数，旧文件由补全任务填写，未填写时为0
+// PHash为图片内容的感知哈希，用于查找相似图片，无法解码的文件为空
 type File struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	TelegramFileID   string    `gorm:"size:255;not null;uniqueIndex" json:"telegram_file_id"`
+	MD5Hash          string    `gorm:"size:32;index" json:"md5_hash"`
+	SHA256Hash       string    `gorm:"size:64;uniqueIndex:idx_files_sha256_mode" json:"sha256_hash"`
+	PHash            string    `gorm:"column:phash;size:16;index" json:"phash"`
+	Storage          string    `gorm:"size:20;not null;default:telegram" json:"storage"`
+	UploadMode       string    `gorm:"size:20;not null;default:photo;uniqueIndex:idx_files_sha256_mode" json:"upload_mode"`
+	TelegramBot      string    `gorm:"size:32;index" json:"telegram_bot"`
+	MimeType         string    `gorm:"size:100" json:"mime_type"`
+	Width            int       `gorm:"not null;default:0" json:"width"`
+	Height           int       `gorm:"not null;default:0" json:"height"`
+	Size             int64     `gorm:"not null;default:0" json:"size"`
+	OriginalFilename string    `gorm:"size:255" json:"original_filename"`
+	DuplicateOf      uint      `gorm:"not null;default:0" json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// FileChunk 文件分块，超过Telegram下载限制的文件拆分为多条消息存储
+type FileChunk struct {
 	ID             uint      `gorm:"primaryKey" json:"id"`
-	TelegramFileID string    `gorm:"size:255;not null;uniqueIndex" json:"telegram_file_id"`
-	MD5Hash        string    `gorm:"size:32;uniqueIndex" json:"md5_hash"`
+	FileID         uint      `gorm:"not null;uniqueIndex:idx_file_chunks_file_index" json:"file_id"`
+	ChunkIndex     int       `gorm:"not null;uniqueIndex:idx_file_chunks_file_index" json:"chunk_index"`
+	TelegramFileID string    `gorm:"size:255;not null" json:"telegram_file_id"`
+	Size           int64     `gorm:"not null" json:"size"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// FileVariant 图片的尺寸版本，由Telegram在发送图片时生成
+type FileVariant struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	FileID         uint      `gorm:"not null;index" json:"file_id"`
+	TelegramFileID string    `gorm:"size:255;not null" json:"telegram_file_id"`
+	Width          int       `json:"width"`
+	Height         int       `json:"height"`
+	FileSize       int64     `json:"file_size"`
 	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 // Image 图片模型
@@ -50,11 +97,80 @@ type Image struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// ImageMetadata 图片的元数据，上传时从用户上传的原始文件中提取
+type ImageMetadata struct {
+	ID          uint       `gorm:"primaryKey" json:"-"`
+	ImageID     uint       `gorm:"not null;uniqueIndex" json:"image_id"`
+	CameraMake  string     `gorm:"size:100" json:"camera_make,omitempty"`
+	CameraModel string     `gorm:"size:100" json:"camera_model,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty"`
+	Orientation int        `json:"orientation,omitempty"`
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	HasGPS      bool       `json:"has_gps"`
+	ExifPolicy  string     `gorm:"size:20" json:"exif_policy"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
 // CreateFile 创建文件记录
 func CreateFile(file *File) error {
 	return DB.Create(file).Error
 }
 
+// CreateFileWithParts 在同一事务中创建文件记录及其分块和尺寸版本
+// 已存在相同SHA-256和上传模式的文件时返回ErrDuplicateFile
+func CreateFileWithParts(file *File, chunks []FileChunk, variants []FileVariant) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(file).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrDuplicateFile
+			}
+			return err
+		}
+
+		if len(chunks) > 0 {
+			for i := range chunks {
+				chunks[i].FileID = file.ID
+				chunks[i].ChunkIndex = i
+			}
+			if err := tx.Create(&chunks).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(variants) > 0 {
+			for i := range variants {
+				variants[i].FileID = file.ID
+			}
+			if err := tx.Create(&variants).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetFileChunks 按顺序获取文件的分块，未分块的文件返回空列表
+func GetFileChunks(fileID uint) ([]FileChunk, error) {
+	var chunks []FileChunk
+	err := DB.Where("file_id = ?", fileID).Order("chunk_index ASC").Find(&chunks).Error
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// GetFileVariants 获取文件的尺寸版本，按宽度从小到大排列
+func GetFileVariants(fileID uint) ([]FileVariant, error) {
+	var variants []FileVariant
+	err := DB.Where("file_id = ?", fileID).Order("width ASC, id ASC").Find(&variants).Error
+	if err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
 // GetFileByID 根据ID获取文件
 func GetFileByID(id uint) (*File, error) {
 	var file File
@@ -75,6 +191,55 @@ func GetFileByMD5Hash(md5Hash string) (*File, error) {
 	return &file, nil
 }
 
+// GetFileBySHA256Hash 根据SHA-256哈希获取文件，指定uploadModes时只查找以这些模式上传的文件
+func GetFileBySHA256Hash(sha256Hash string, uploadModes ...string) (*File, error) {
+	var file File
+	query := DB.Where("sha256_hash = ?", sha256Hash)
+	if len(uploadModes) > 0 {
+		query = query.Where("upload_mode IN ?", uploadModes)
+	}
+	err := query.Order("id ASC").First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetFileByMD5HashWithoutSHA256 根据MD5哈希获取尚未填写SHA-256的旧文件，uploadModes含义同GetFileBySHA256Hash
+func GetFileByMD5HashWithoutSHA256(md5Hash string, uploadModes ...string) (*File, error) {
+	var file File
+	query := DB.Where("md5_hash = ? AND COALESCE(sha256_hash, '') = '' AND duplicate_of = 0", md5Hash)
+	if len(uploadModes) > 0 {
+		query = query.Where("upload_mode IN ?", uploadModes)
+	}
+	err := query.Order("id ASC").First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// SetFileSHA256 填写校验得到的SHA-256，已有相同内容和上传模式的文件时改为记录DuplicateOf
+func SetFileSHA256(file *File, sha256Hash string) error {
+	err := DB.Model(&File{}).Where("id = ?", file.ID).Update("sha256_hash", sha256Hash).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		if err == nil {
+			file.SHA256Hash = sha256Hash
+		}
+		return err
+	}
+
+	existing, err := GetFileBySHA256Hash(sha256Hash, file.UploadMode)
+	if err != nil {
+		return err
+	}
+	if err := DB.Model(&File{}).Where("id = ?", file.ID).Update("duplicate_of", existing.ID).Error; err != nil {
+		return err
+	}
+	file.DuplicateOf = existing.ID
+	return nil
+}
+
 // GetFileByTelegramFileID 根据TelegramFileID获取文件
 func GetFileByTelegramFileID(telegramFileID string) (*File, error) {
 	var file File
@@ -85,11 +250,125 @@ func GetFileByTelegramFileID(telegramFileID string) (*File, error) {
 	return &file, nil
 }
 
+// UpdateFile 更新文件的指定字段
+func UpdateFile(id uint, fields map[string]interface{}) error {
+	return DB.Model(&File{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// filesWithoutSHA256 可以补全SHA-256的文件，以photo模式上传的文件已被压缩，无法得到原始内容的SHA-256
+const filesWithoutSHA256 = "COALESCE(sha256_hash, '') = '' AND upload_mode <> 'photo' AND duplicate_of = 0"
+
+// filesWithoutInfo 大小、SHA-256或感知哈希未填写的文件
+const filesWithoutInfo = "size = 0 OR (" + filesWithoutSHA256 + ") OR COALESCE(phash, '') = ''"
+
+// GetFilesWithoutInfo 按ID顺序获取大小、SHA-256或感知哈希未填写的文件，afterID用于分批查询
+func GetFilesWithoutInfo(afterID uint, limit int) ([]File, error) {
+	var files []File
+	err := DB.Where("("+filesWithoutInfo+") AND id > ?", afterID).Order("id ASC").Limit(limit).Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// CountFilesWithoutSHA256 统计可以补全SHA-256的文件数
+func CountFilesWithoutSHA256() (int64, error) {
+	var count int64
+	err := DB.Model(&File{}).Where(filesWithoutSHA256).Count(&count).Error
+	return count, err
+}
+
+// CountFilesWithoutInfo 统计大小、SHA-256或感知哈希未填写的文件数
+func CountFilesWithoutInfo() (int64, error) {
+	var count int64
+	err := DB.Model(&File{}).Where(filesWithoutInfo).Count(&count).Error
+	return count, err
+}
+
+// GetFilesWithPHash 获取已计算感知哈希的文件，按ID从新到旧最多返回limit个
+func GetFilesWithPHash(limit int) ([]File, error) {
+	var files []File
+	err := DB.Where("phash <> ''").Order("id DESC").Limit(limit).Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
 // CreateImage 创建图片记录
 func CreateImage(image *Image) error {
 	return DB.Create(image).Error
 }
 
+// CreateImageWithMetadata 创建图片记录及其元数据
+func CreateImageWithMetadata(image *Image, metadata *ImageMetadata) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(image).Error; err != nil {
+			return err
+		}
+
+		metadata.ImageID = image.ID
+		return tx.Create(metadata).Error
+	})
+}
+
+// GetImageMetadataByImageIDs 批量获取图片的元数据，按图片ID索引
+func GetImageMetadataByImageIDs(imageIDs []uint) (map[uint]ImageMetadata, error) {
+	result := make(map[uint]ImageMetadata, len(imageIDs))
+	if len(imageIDs) == 0 {
+		return result, nil
+	}
+
+	var metadata []ImageMetadata
+	if err := DB.Where("image_id IN ?", imageIDs).Find(&metadata).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range metadata {
+		result[m.ImageID] = m
+	}
+	return result, nil
+}
+
+// ImageHash 图片及其文件的感知哈希
+type ImageHash struct {
+	ImageID        uint
+	FileID         uint
+	TelegramFileID string
+	PHash          string `gorm:"column:phash"`
+	CreatedAt      time.Time
+}
+
+// GetImageHashesByUserID 获取用户所有已计算感知哈希的图片
+func GetImageHashesByUserID(userID string) ([]ImageHash, error) {
+	var hashes []ImageHash
+	err := DB.Table("images").
+		Select("images.id AS image_id, images.file_id, files.telegram_file_id, files.phash, images.created_at").
+		Joins("JOIN files ON files.id = images.file_id").
+		Where("images.user_id = ? AND files.phash <> ''", userID).
+		Scan(&hashes).Error
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// GetImagesByFileIDs 获取引用这些文件的图片，按文件ID分组
+func GetImagesByFileIDs(fileIDs []uint) (map[uint][]Image, error) {
+	result := make(map[uint][]Image, len(fileIDs))
+	if len(fileIDs) == 0 {
+		return result, nil
+	}
+
+	var images []Image
+	if err := DB.Where("file_id IN ?", fileIDs).Order("id ASC").Find(&images).Error; err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		result[image.FileID] = append(result[image.FileID], image)
+	}
+	return result, nil
+}
+
 // GetImageByFileIDAndUserID 根据FileID和UserID获取图片
 func GetImageByFileIDAndUserID(fileID uint, userID string) (*Image, error) {
 	var image Image
@@ -134,7 +413,12 @@ func GetImagesByUserID(userID string, page, pageSize int) ([]Image, int64, error
 
 // DeleteImage 删除图片
 func DeleteImage(id uint) error {
-	return DB.Delete(&Image{}, id).Error
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("image_id = ?", id).Delete(&ImageMetadata{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Image{}, id).Error
+	})
 }
 
 // GetImagesWithFilter 根据条件筛选图片
@@ -188,6 +472,10 @@ func GetStats() (map[string]interface{}, error) {
 		Distinct("user_id").
 		Count(&userCount)
 
+	// 获取存储占用，未补全大小的旧文件不计入
+	var storageBytes int64
+	DB.Model(&File{}).Select("COALESCE(SUM(size), 0)").Scan(&storageBytes)
+
 	// 获取用户上传排行
 	type UserStat struct {
 		UserID string
@@ -206,6 +494,7 @@ func GetStats() (map[string]interface{}, error) {
 		"total_images":  totalImages,
 		"today_images":  todayImages,
 		"user_count":    userCount,
+		"storage_bytes": storageBytes,
 		"user_rankings": userStats,
 	}, nil
 }
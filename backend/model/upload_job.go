@@ -0,0 +1,175 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 上传任务状态
+const (
+	UploadJobPending    = "pending"
+	UploadJobProcessing = "processing"
+	UploadJobSucceeded  = "succeeded"
+	UploadJobFailed     = "failed"
+)
+
+// UploadJob 异步上传任务，上传的文件先暂存到本地，由后台任务上传到存储后端
+// 处理中的任务由LockedBy对应的进程持有租约，租约到期前需要续期，到期未续期的任务可被重新领取
+// 暂存文件保存在SpoolHost节点的本地磁盘上，暂存目录不共享时只能由该节点执行
+type UploadJob struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         string     `gorm:"size:100;not null;index" json:"user_id"`
+	UploadIP       string     `gorm:"size:50" json:"upload_ip"`
+	Filename       string     `gorm:"size:255" json:"filename"`
+	ContentType    string     `gorm:"size:100" json:"content_type"`
+	Mode           string     `gorm:"size:20" json:"mode"`
+	Size           int64      `json:"size"`
+	SpoolPath      string     `gorm:"size:500" json:"-"`
+	SpoolHost      string     `gorm:"size:100;index" json:"-"`
+	Status         string     `gorm:"size:20;not null;index:idx_upload_jobs_status_next_run" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts    int        `gorm:"not null;default:3" json:"max_attempts"`
+	Error          string     `gorm:"size:1000" json:"error,omitempty"`
+	NextRunAt      time.Time  `gorm:"index:idx_upload_jobs_status_next_run" json:"next_run_at"`
+	LockedBy       string     `gorm:"size:100" json:"locked_by,omitempty"`
+	LockedUntil    *time.Time `gorm:"index" json:"locked_until,omitempty"`
+	FileID         uint       `json:"file_id,omitempty"`
+	ImageID        uint       `json:"image_id,omitempty"`
+	TelegramFileID string     `gorm:"size:255" json:"telegram_file_id,omitempty"`
+	Existing       bool       `json:"existing"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// CreateUploadJob 创建上传任务
+func CreateUploadJob(job *UploadJob) error {
+	return DB.Create(job).Error
+}
+
+// GetUploadJobByID 根据ID获取上传任务
+func GetUploadJobByID(id uint) (*UploadJob, error) {
+	var job UploadJob
+	if err := DB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// spoolHostScope 只查找暂存文件在host节点上的任务，host为空时不限制
+// 添加SpoolHost前创建的任务没有记录节点，任何节点都可以领取
+func spoolHostScope(host string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if host == "" {
+			return db
+		}
+		return db.Where("spool_host IN ?", []string{host, ""})
+	}
+}
+
+// GetDueUploadJobIDs 获取到达执行时间、暂存文件在host节点上的待处理任务ID，按创建顺序排列
+func GetDueUploadJobIDs(host string, limit int) ([]uint, error) {
+	var ids []uint
+	err := DB.Model(&UploadJob{}).
+		Scopes(spoolHostScope(host)).
+		Where("status = ? AND next_run_at <= ?", UploadJobPending, time.Now()).
+		Order("id ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ClaimUploadJob 将暂存文件在host节点上的待处理任务标记为处理中并由owner持有租约，任务已被其他进程领取时返回false
+func ClaimUploadJob(id uint, owner, host string, lease time.Duration) (bool, error) {
+	until := time.Now().Add(lease)
+	result := DB.Model(&UploadJob{}).
+		Scopes(spoolHostScope(host)).
+		Where("id = ? AND status = ?", id, UploadJobPending).
+		Updates(map[string]interface{}{
+			"status":       UploadJobProcessing,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"locked_by":    owner,
+			"locked_until": &until,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RenewUploadJobLease 延长owner持有的任务租约，租约已失效并被其他进程领取时返回false
+func RenewUploadJobLease(id uint, owner string, lease time.Duration) (bool, error) {
+	until := time.Now().Add(lease)
+	result := DB.Model(&UploadJob{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, UploadJobProcessing, owner).
+		Update("locked_until", &until)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleaseUploadJob 更新owner持有的任务并释放租约，租约已失效并被其他进程领取时返回false
+func ReleaseUploadJob(id uint, owner string, fields map[string]interface{}) (bool, error) {
+	fields["locked_by"] = ""
+	fields["locked_until"] = nil
+	result := DB.Model(&UploadJob{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, UploadJobProcessing, owner).
+		Updates(fields)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// UpdateUploadJob 更新上传任务的指定字段
+func UpdateUploadJob(id uint, fields map[string]interface{}) error {
+	return DB.Model(&UploadJob{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// ReclaimExpiredUploadJobs 将租约已到期的处理中任务恢复为待处理，用于执行任务的进程退出或失去响应后继续执行
+// 没有租约的处理中任务来自添加租约前的版本，同样恢复
+func ReclaimExpiredUploadJobs() (int64, error) {
+	now := time.Now()
+	result := DB.Model(&UploadJob{}).
+		Where("status = ? AND (locked_until IS NULL OR locked_until < ?)", UploadJobProcessing, now).
+		Updates(map[string]interface{}{
+			"status":       UploadJobPending,
+			"next_run_at":  now,
+			"locked_by":    "",
+			"locked_until": nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// GetUploadJobs 分页获取上传任务，status为空时返回所有状态的任务
+func GetUploadJobs(status, userID string, page, pageSize int) ([]UploadJob, int64, error) {
+	var jobs []UploadJob
+	var total int64
+	query := DB.Model(&UploadJob{})
+
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	// 获取总数
+	query.Count(&total)
+
+	// 分页查询
+	err := query.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id DESC").
+		Find(&jobs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return jobs, total, nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/telegram-photo/config"
 	"github.com/telegram-photo/middleware"
 	"github.com/telegram-photo/model"
+	"github.com/telegram-photo/service"
 )
 
 // New creates and configures the application server, returning the router and port.
@@ -23,6 +24,33 @@ func New() (*gin.Engine, string, error) {
 		return nil, "", fmt.Errorf("数据库初始化失败: %w", err)
 	}
 
+	if err := service.CheckProxyConfig(); err != nil {
+		return nil, "", fmt.Errorf("代理配置错误: %w", err)
+	}
+
+	if err := service.InitTelegramBots(); err != nil {
+		return nil, "", fmt.Errorf("Telegram机器人初始化失败: %w", err)
+	}
+
+	if err := service.InitStorage(); err != nil {
+		return nil, "", fmt.Errorf("存储初始化失败: %w", err)
+	}
+
+	if err := service.InitCache(); err != nil {
+		return nil, "", fmt.Errorf("缓存初始化失败: %w", err)
+	}
+
+	if err := service.InitUploadQueue(); err != nil {
+		return nil, "", fmt.Errorf("上传任务队列初始化失败: %w", err)
+	}
+
+	if err := service.InitTusStore(); err != nil {
+		return nil, "", fmt.Errorf("断点续传初始化失败: %w", err)
+	}
+
+	// 升级前上传的文件没有SHA-256，下载校验后补全
+	service.StartSHA256Backfill()
+
 	router := gin.Default()
 	registerMiddlewares(router)
 	registerRoutes(router)
@@ -0,0 +1,462 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrCacheMiss 缓存中不存在该内容
+var ErrCacheMiss = errors.New("缓存未命中")
+
+// DiskCache 本地磁盘缓存，总大小超过上限时按最近最少使用淘汰
+type DiskCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	calls   map[string]*cacheCall
+	hits    int64
+	misses  int64
+}
+
+// cacheEntry 缓存文件，name为缓存键的哈希
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+// cacheCall 正在进行的回源请求，同一缓存键的并发未命中只回源一次
+type cacheCall struct {
+	done chan struct{}
+	err  error
+}
+
+// CacheStats 缓存统计信息
+type CacheStats struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+	Entries  int     `json:"entries"`
+	Size     int64   `json:"size"`
+	MaxSize  int64   `json:"max_size"`
+}
+
+// NewDiskCache 创建磁盘缓存，并加载目录中已有的缓存文件
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建缓存目录失败: %w", err)
+	}
+
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		calls:   make(map[string]*cacheCall),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var (
+	imageCache     *DiskCache
+	imageCacheOnce sync.Once
+)
+
+// InitCache 根据配置初始化图片缓存，只在第一次调用时生效
+func InitCache() error {
+	var err error
+	imageCacheOnce.Do(func() {
+		dir := viper.GetString("cache.dir")
+		if dir == "" {
+			dir = "./cache"
+		}
+
+		maxSize := viper.GetInt64("cache.max_size_mb")
+		if maxSize <= 0 {
+			maxSize = 1024
+		}
+
+		imageCache, err = NewDiskCache(dir, maxSize*1024*1024)
+	})
+	return err
+}
+
+// ImageCache 获取图片缓存，未初始化时使用系统临时目录
+// 缓存目录无法创建时返回nil，调用方应绕过缓存
+func ImageCache() *DiskCache {
+	imageCacheOnce.Do(func() {
+		cache, err := NewDiskCache(filepath.Join(os.TempDir(), "telegram-photo-cache"), 1024*1024*1024)
+		if err != nil {
+			log.Printf("创建图片缓存失败: %v", err)
+			return
+		}
+		imageCache = cache
+	})
+	return imageCache
+}
+
+// FetchUncached 不经过缓存，将fill写出的内容保存到临时文件，返回的文件在关闭时删除
+func FetchUncached(fill func(w io.Writer) error) (ReadSeekCloser, error) {
+	tmp, err := os.CreateTemp("", "telegram-photo-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := fill(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &tempFile{File: tmp}, nil
+}
+
+// Open 打开缓存的内容，不存在时返回ErrCacheMiss
+func (c *DiskCache) Open(key string) (*os.File, error) {
+	f, err := c.open(cacheName(key))
+	c.mu.Lock()
+	if err == nil {
+		c.hits++
+	} else if errors.Is(err, ErrCacheMiss) {
+		c.misses++
+	}
+	c.mu.Unlock()
+	return f, err
+}
+
+// Put 写入缓存
+func (c *DiskCache) Put(key string, data []byte) error {
+	_, err := c.write(cacheName(key), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, true)
+	return err
+}
+
+// Fetch 读取缓存，未命中时调用fill回源并写入缓存
+// 同一缓存键的并发未命中只调用一次fill，其余请求等待其完成后读取缓存
+// 返回的文件由调用方关闭
+func (c *DiskCache) Fetch(key string, fill func(w io.Writer) error) (ReadSeekCloser, error) {
+	f, err := c.Open(key)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, ErrCacheMiss) {
+		return nil, err
+	}
+
+	name := cacheName(key)
+
+	c.mu.Lock()
+	if call, ok := c.calls[name]; ok {
+		c.mu.Unlock()
+		<-call.done
+		if call.err != nil {
+			return nil, call.err
+		}
+		// 内容超过缓存上限未被缓存时，自行回源
+		if f, err := c.open(name); err == nil {
+			return f, nil
+		}
+		return c.write(name, fill, false)
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[name] = call
+	c.mu.Unlock()
+
+	rc, err := c.write(name, fill, true)
+
+	call.err = err
+	c.mu.Lock()
+	delete(c.calls, name)
+	c.mu.Unlock()
+	close(call.done)
+
+	return rc, err
+}
+
+// Stream 读取缓存，命中时返回缓存文件，由调用方关闭
+// 未命中时调用fill回源，内容边写入w边保存到缓存，回源完成后加入缓存并返回nil，w写入失败时继续完成缓存
+// size为已知的内容大小，超过缓存上限时不写入缓存直接回源；同一缓存键正在回源时等待其完成后读取缓存
+// 返回错误时w可能已写入部分内容
+func (c *DiskCache) Stream(key string, size int64, w io.Writer, fill func(w io.Writer) error) (ReadSeekCloser, error) {
+	name := cacheName(key)
+	if f, err := c.open(name); err == nil {
+		return f, nil
+	} else if !errors.Is(err, ErrCacheMiss) {
+		return nil, err
+	}
+	if size > c.maxSize {
+		return nil, fill(w)
+	}
+
+	c.mu.Lock()
+	if call, ok := c.calls[name]; ok {
+		c.mu.Unlock()
+		<-call.done
+		if f, err := c.open(name); err == nil {
+			return f, nil
+		}
+		// 回源失败或内容超过缓存上限时自行回源
+		return nil, fill(w)
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[name] = call
+	c.mu.Unlock()
+
+	client := &detachedWriter{w: w}
+	f, err := c.write(name, func(tmp io.Writer) error {
+		return fill(io.MultiWriter(tmp, client))
+	}, true)
+	if f != nil {
+		f.Close()
+	}
+
+	call.err = err
+	c.mu.Lock()
+	delete(c.calls, name)
+	c.mu.Unlock()
+	close(call.done)
+
+	if err == nil {
+		err = client.err
+	}
+	return nil, err
+}
+
+// detachedWriter 写入失败（如客户端断开）后丢弃之后的内容并记录错误，不中断同时进行的缓存写入
+type detachedWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (d *detachedWriter) Write(p []byte) (int, error) {
+	if d.err == nil {
+		_, d.err = d.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// Stats 获取缓存统计信息
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: c.lru.Len(),
+		Size:    c.size,
+		MaxSize: c.maxSize,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRatio = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// ReadSeekCloser 可定位读取的内容
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// open 打开缓存文件并标记为最近使用
+func (c *DiskCache) open(name string) (*os.File, error) {
+	c.mu.Lock()
+	elem, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	f, err := os.Open(c.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.remove(name)
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+
+	// 更新修改时间，重启后按此恢复使用顺序
+	now := time.Now()
+	os.Chtimes(f.Name(), now, now)
+	return f, nil
+}
+
+// write 将fill写出的内容保存为缓存文件
+// store为false或内容超过缓存上限时不加入缓存，返回的临时文件在关闭时删除
+func (c *DiskCache) write(name string, fill func(w io.Writer) error, store bool) (ReadSeekCloser, error) {
+	path := c.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cache-*")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fill(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	fi, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if !store || fi.Size() > c.maxSize {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+		return &tempFile{File: tmp}, nil
+	}
+
+	// 先关闭再重命名，兼容Windows
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	c.add(name, fi.Size())
+
+	return os.Open(path)
+}
+
+// add 记录缓存文件，并在超过上限时淘汰最近最少使用的文件
+func (c *DiskCache) add(name string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[name]; ok {
+		entry := elem.Value.(*cacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[name] = c.lru.PushFront(&cacheEntry{name: name, size: size})
+		c.size += size
+	}
+
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		elem := c.lru.Back()
+		entry := elem.Value.(*cacheEntry)
+		c.lru.Remove(elem)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+		os.Remove(c.path(entry.name))
+	}
+}
+
+// remove 移除缓存记录
+func (c *DiskCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+		delete(c.entries, name)
+	}
+}
+
+// load 扫描缓存目录，按修改时间恢复使用顺序，并清理残留的临时文件
+func (c *DiskCache) load() error {
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".cache-") {
+			os.Remove(path)
+			return nil
+		}
+		if len(d.Name()) != sha256.Size*2 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{name: d.Name(), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		c.add(f.name, f.size)
+	}
+	return nil
+}
+
+// path 缓存文件路径，按文件名前两位分目录
+func (c *DiskCache) path(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// cacheName 缓存键可能包含任意字符，使用其哈希作为文件名
+func cacheName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// tempFile 未加入缓存的临时文件，关闭时删除
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.File.Name())
+	return err
+}
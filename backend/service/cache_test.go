@@ -0,0 +1,254 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// putTestEntry 写入指定大小的缓存内容
+func putTestEntry(t *testing.T, c *DiskCache, key string, size int) {
+	t.Helper()
+	if err := c.Put(key, bytes.Repeat([]byte{'x'}, size)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cachedKeys 缓存中仍存在的键
+func cachedKeys(c *DiskCache, keys []string) string {
+	var kept []string
+	for _, key := range keys {
+		if f, err := c.open(cacheName(key)); err == nil {
+			f.Close()
+			kept = append(kept, key)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	keys := []string{"a", "b", "c", "d"}
+	tests := []struct {
+		name string
+		// ops 依次执行，"+key"写入10字节，"?key"读取
+		ops     []string
+		maxSize int64
+		want    string
+	}{
+		{"within limit", []string{"+a", "+b", "+c"}, 30, "a,b,c"},
+		{"evicts oldest", []string{"+a", "+b", "+c", "+d"}, 30, "b,c,d"},
+		{"read refreshes", []string{"+a", "+b", "+c", "?a", "+d"}, 30, "a,c,d"},
+		{"evicts in use order", []string{"+a", "+b", "?a", "+c", "+d"}, 20, "c,d"},
+		{"rewrite refreshes", []string{"+a", "+b", "+a", "+c"}, 20, "a,c"},
+		{"skips entries larger than the cache", []string{"+a", "+b"}, 5, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewDiskCache(t.TempDir(), tc.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, op := range tc.ops {
+				switch op[0] {
+				case '+':
+					putTestEntry(t, c, op[1:], 10)
+				case '?':
+					f, err := c.Open(op[1:])
+					if err != nil {
+						t.Fatalf("%s: %v", op, err)
+					}
+					f.Close()
+				}
+			}
+			if got := cachedKeys(c, keys); got != tc.want {
+				t.Fatalf("cached %q, want %q", got, tc.want)
+			}
+			if stats := c.Stats(); stats.Size > max(tc.maxSize, 10) {
+				t.Fatalf("cache size %d exceeds %d", stats.Size, tc.maxSize)
+			}
+		})
+	}
+}
+
+func TestDiskCacheReloadKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		putTestEntry(t, c, key, 10)
+		// 部分文件系统的修改时间精度较低，间隔一段时间保证顺序
+		time.Sleep(20 * time.Millisecond)
+	}
+	f, err := c.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// 重启后按修改时间恢复使用顺序，最近读取的a不被淘汰
+	reloaded, err := NewDiskCache(dir, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestEntry(t, reloaded, "d", 10)
+	if got := cachedKeys(reloaded, []string{"a", "b", "c", "d"}); got != "a,c,d" {
+		t.Fatalf("cached %q after reload, want %q", got, "a,c,d")
+	}
+}
+
+func TestDiskCacheSingleFlight(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxSize   int64
+		fail      bool
+		wantFills int64
+	}{
+		{"fills once", 1 << 20, false, 1},
+		// 内容超过缓存上限时等待的请求各自回源
+		{"oversize refills", 4, false, 8},
+		{"error shared", 1 << 20, true, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewDiskCache(t.TempDir(), tc.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fills atomic.Int64
+			release := make(chan struct{})
+			fill := func(w io.Writer) error {
+				if fills.Add(1) == 1 {
+					<-release
+				}
+				if tc.fail {
+					return errors.New("backend down")
+				}
+				_, err := io.WriteString(w, "content")
+				return err
+			}
+
+			const n = 8
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					body, err := c.Fetch("key", fill)
+					if err != nil {
+						errs <- err
+						return
+					}
+					defer body.Close()
+					data, err := io.ReadAll(body)
+					if err == nil && string(data) != "content" {
+						err = errors.New("unexpected content " + string(data))
+					}
+					errs <- err
+				}()
+			}
+
+			// 等待所有请求都在等待第一次回源
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				c.mu.Lock()
+				call := c.calls[cacheName("key")]
+				c.mu.Unlock()
+				if call != nil || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if tc.fail != (err != nil) {
+					t.Fatalf("Fetch error = %v, want failure %v", err, tc.fail)
+				}
+			}
+			if got := fills.Load(); got != tc.wantFills {
+				t.Fatalf("fill called %d times, want %d", got, tc.wantFills)
+			}
+		})
+	}
+}
+
+func TestDiskCacheStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int64
+		wantCache bool
+	}{
+		{"cached after streaming", 7, true},
+		{"unknown size", 0, true},
+		{"larger than cache", 1 << 20, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewDiskCache(t.TempDir(), 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fill := func(w io.Writer) error {
+				_, err := io.WriteString(w, "content")
+				return err
+			}
+
+			var out bytes.Buffer
+			body, err := c.Stream("key", tc.size, &out, fill)
+			if err != nil || body != nil {
+				t.Fatalf("Stream on miss = %v, %v, want the content written to w", body, err)
+			}
+			if out.String() != "content" {
+				t.Fatalf("streamed %q", out.String())
+			}
+
+			cached, err := c.Open("key")
+			if tc.wantCache != (err == nil) {
+				t.Fatalf("cached = %v, want %v", err == nil, tc.wantCache)
+			}
+			if cached != nil {
+				cached.Close()
+			}
+		})
+	}
+}
+
+// failingWriter 模拟断开的客户端
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("client gone") }
+
+func TestDiskCacheStreamClientGone(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Stream("key", 0, failingWriter{}, func(w io.Writer) error {
+		_, err := io.WriteString(w, "content")
+		return err
+	})
+	if err == nil {
+		t.Fatal("client write error should be reported")
+	}
+
+	// 客户端断开不影响写入缓存
+	body, err := c.Open("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if data, _ := io.ReadAll(body); string(data) != "content" {
+		t.Fatalf("cached %q", data)
+	}
+}
@@ -0,0 +1,564 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// EXIF处理策略
+const (
+	// ExifKeep 保留原始文件中的元数据
+	ExifKeep = "keep"
+	// ExifStripGPS 删除EXIF中的GPS信息，无法解析的EXIF和可能包含GPS的XMP整段删除
+	ExifStripGPS = "strip_gps"
+	// ExifStripAll 删除EXIF和XMP元数据
+	ExifStripAll = "strip_all"
+)
+
+// maxExifSize 读入内存解析的EXIF最大长度
+const maxExifSize = 4 * 1024 * 1024
+
+// EXIF标签
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagOffsetTimeOrig   = 0x9011
+)
+
+// ExifData 从EXIF中提取的信息
+type ExifData struct {
+	Make        string
+	Model       string
+	TakenAt     *time.Time
+	Orientation int
+	// HasGPS 原始文件是否包含GPS信息
+	HasGPS bool
+}
+
+// ExifPolicy 配置的EXIF处理策略
+func ExifPolicy() string {
+	switch policy := viper.GetString("upload.exif_policy"); policy {
+	case ExifKeep, ExifStripAll:
+		return policy
+	default:
+		return ExifStripGPS
+	}
+}
+
+// metadataBlock 文件中的一段元数据
+type metadataBlock struct {
+	// off、n 整个段或数据块的位置，删除时移除这部分
+	off, n int64
+	// exif 是否为EXIF，payloadOff为TIFF数据的位置
+	exif       bool
+	payloadOff int64
+	payloadN   int64
+	// rebuild 修改TIFF数据后重新生成整个数据块，为空时直接替换TIFF数据
+	rebuild func(tiff []byte) []byte
+	// always 无法只删除GPS的元数据，strip_gps策略下同样删除
+	always bool
+}
+
+// patch 重写文件时对一段内容的修改，data为nil时删除该段
+type patch struct {
+	off, n int64
+	data   []byte
+}
+
+// applyExifPolicy 提取EXIF信息并按策略处理元数据
+// 需要修改文件时返回新的临时文件，调用方负责关闭；只支持JPEG、PNG和WebP，其他格式原样保存
+func applyExifPolicy(upload *UploadFile, info *ImageInfo, policy string) (*ExifData, *UploadFile, error) {
+	r, size := upload.f, upload.Size
+
+	var blocks []metadataBlock
+	var err error
+	switch info.Format {
+	case "jpeg":
+		blocks, err = jpegMetadata(r, size)
+	case "png":
+		blocks, err = pngMetadata(r, size)
+	case "webp":
+		blocks, err = webpMetadata(r, size)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析图片元数据失败: %w", err)
+	}
+
+	data := &ExifData{}
+	var patches []patch
+	for _, block := range blocks {
+		if !block.exif {
+			if policy == ExifStripAll || (policy == ExifStripGPS && block.always) {
+				patches = append(patches, patch{off: block.off, n: block.n})
+			}
+			continue
+		}
+
+		tiff, parsed := readExif(r, block)
+		if parsed != nil {
+			data = parsed
+		}
+
+		switch {
+		case policy == ExifKeep:
+		case policy == ExifStripAll || parsed == nil:
+			patches = append(patches, patch{off: block.off, n: block.n})
+		default:
+			// GPS IFD无法解析时可能仍被其他程序读出，与无法解析的EXIF一样整段删除
+			changed, err := scrubGPS(tiff)
+			if err != nil {
+				patches = append(patches, patch{off: block.off, n: block.n})
+				break
+			}
+			if !changed {
+				break
+			}
+			if block.rebuild != nil {
+				patches = append(patches, patch{off: block.off, n: block.n, data: block.rebuild(tiff)})
+			} else {
+				patches = append(patches, patch{off: block.payloadOff, n: block.payloadN, data: tiff})
+			}
+		}
+	}
+
+	if len(patches) == 0 {
+		return data, nil, nil
+	}
+	if info.Format == "webp" {
+		patches = append(patches, webpHeaderPatches(r, size, patches)...)
+	}
+
+	processed, err := SpoolUpload(rewriteFile(r, size, patches), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	processed.info = info
+	return data, processed, nil
+}
+
+// readExif 读取并解析EXIF，无法解析时返回nil
+func readExif(r io.ReaderAt, block metadataBlock) ([]byte, *ExifData) {
+	if block.payloadN <= 0 || block.payloadN > maxExifSize {
+		return nil, nil
+	}
+	tiff := make([]byte, block.payloadN)
+	if _, err := r.ReadAt(tiff, block.payloadOff); err != nil {
+		return nil, nil
+	}
+	data, err := ParseExif(tiff)
+	if err != nil {
+		return nil, nil
+	}
+	return tiff, data
+}
+
+// rewriteFile 按修改列表重新组合文件内容
+func rewriteFile(r io.ReaderAt, size int64, patches []patch) io.Reader {
+	sort.Slice(patches, func(i, j int) bool { return patches[i].off < patches[j].off })
+
+	var readers []io.Reader
+	var offset int64
+	for _, p := range patches {
+		if p.off < offset {
+			continue
+		}
+		readers = append(readers, io.NewSectionReader(r, offset, p.off-offset))
+		if p.data != nil {
+			readers = append(readers, bytes.NewReader(p.data))
+		}
+		offset = p.off + p.n
+	}
+	readers = append(readers, io.NewSectionReader(r, offset, size-offset))
+	return io.MultiReader(readers...)
+}
+
+// jpegMetadata 查找JPEG中的EXIF和XMP段
+func jpegMetadata(r io.ReaderAt, size int64) ([]metadataBlock, error) {
+	var blocks []metadataBlock
+	header := make([]byte, 4)
+	for offset := int64(2); offset+4 <= size; {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, err
+		}
+		if header[0] != 0xff {
+			return nil, fmt.Errorf("JPEG段结构错误")
+		}
+
+		marker := header[1]
+		switch {
+		case marker == 0xff:
+			// 填充字节
+			offset++
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd8):
+			offset += 2
+			continue
+		case marker == 0xda || marker == 0xd9:
+			// 图像数据开始，之后没有元数据段
+			return blocks, nil
+		}
+
+		length := int64(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 || offset+2+length > size {
+			return nil, fmt.Errorf("JPEG段长度错误")
+		}
+
+		if marker == 0xe1 {
+			id := make([]byte, min(length-2, 35))
+			if _, err := r.ReadAt(id, offset+4); err != nil {
+				return nil, err
+			}
+			switch {
+			case bytes.HasPrefix(id, []byte("Exif\x00\x00")):
+				blocks = append(blocks, metadataBlock{
+					off: offset, n: 2 + length,
+					exif: true, payloadOff: offset + 10, payloadN: length - 8,
+				})
+			case bytes.HasPrefix(id, []byte("http://ns.adobe.com/xap/1.0/\x00")),
+				bytes.HasPrefix(id, []byte("http://ns.adobe.com/xmp/extension/")):
+				// XMP中的exif:GPSLatitude等属性无法只删除GPS信息
+				blocks = append(blocks, metadataBlock{off: offset, n: 2 + length, always: true})
+			}
+		}
+		offset += 2 + length
+	}
+	return blocks, nil
+}
+
+// pngMetadata 查找PNG中的eXIf数据块和保存元数据的文本块
+func pngMetadata(r io.ReaderAt, size int64) ([]metadataBlock, error) {
+	var blocks []metadataBlock
+	header := make([]byte, 8)
+	for offset := int64(8); offset+12 <= size; {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		if offset+12+length > size {
+			return nil, fmt.Errorf("PNG数据块长度错误")
+		}
+
+		switch chunkType := string(header[4:]); chunkType {
+		case "eXIf":
+			blocks = append(blocks, metadataBlock{
+				off: offset, n: 12 + length,
+				exif: true, payloadOff: offset + 8, payloadN: length,
+				rebuild: func(tiff []byte) []byte { return pngChunk("eXIf", tiff) },
+			})
+		case "tEXt", "zTXt", "iTXt":
+			keyword := make([]byte, min(length, 80))
+			if _, err := r.ReadAt(keyword, offset+8); err != nil {
+				return nil, err
+			}
+			keyword, _, _ = bytes.Cut(keyword, []byte{0})
+			// XMP和ImageMagick写入的十六进制EXIF，无法只删除其中的GPS信息
+			if string(keyword) == "XML:com.adobe.xmp" || strings.HasPrefix(string(keyword), "Raw profile type") {
+				blocks = append(blocks, metadataBlock{off: offset, n: 12 + length, always: true})
+			}
+		case "IEND":
+			return blocks, nil
+		}
+		offset += 12 + length
+	}
+	return blocks, nil
+}
+
+// pngChunk 生成PNG数据块
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// webpMetadata 查找WebP中的EXIF和XMP数据块
+func webpMetadata(r io.ReaderAt, size int64) ([]metadataBlock, error) {
+	var blocks []metadataBlock
+	header := make([]byte, 8)
+	for offset := int64(12); offset+8 <= size; {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, err
+		}
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		padded := length + length%2
+		if offset+8+padded > size {
+			return nil, fmt.Errorf("WebP数据块长度错误")
+		}
+
+		switch string(header[:4]) {
+		case "EXIF":
+			block := metadataBlock{
+				off: offset, n: 8 + padded,
+				exif: true, payloadOff: offset + 8, payloadN: length,
+			}
+			// 部分程序写入的EXIF带有JPEG的Exif前缀
+			prefix := make([]byte, 6)
+			if length > 6 {
+				if _, err := r.ReadAt(prefix, offset+8); err == nil && string(prefix) == "Exif\x00\x00" {
+					block.payloadOff += 6
+					block.payloadN -= 6
+				}
+			}
+			blocks = append(blocks, block)
+		case "XMP ":
+			// 与JPEG相同，XMP中可能包含GPS属性
+			blocks = append(blocks, metadataBlock{off: offset, n: 8 + padded, always: true})
+		}
+		offset += 8 + padded
+	}
+	return blocks, nil
+}
+
+// webpHeaderPatches 删除数据块后更新RIFF长度和VP8X中的元数据标志
+func webpHeaderPatches(r io.ReaderAt, size int64, patches []patch) []patch {
+	var removed int64
+	var removedExif, removedXMP bool
+	for _, p := range patches {
+		if p.data != nil {
+			continue
+		}
+		removed += p.n
+		fourcc := make([]byte, 4)
+		r.ReadAt(fourcc, p.off)
+		switch string(fourcc) {
+		case "EXIF":
+			removedExif = true
+		case "XMP ":
+			removedXMP = true
+		}
+	}
+	if removed == 0 {
+		return nil
+	}
+
+	riffSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(riffSize, uint32(size-8-removed))
+	result := []patch{{off: 4, n: 4, data: riffSize}}
+
+	vp8x := make([]byte, 9)
+	if _, err := r.ReadAt(vp8x, 12); err == nil && string(vp8x[:4]) == "VP8X" {
+		flags := vp8x[8]
+		if removedExif {
+			flags &^= 0x08
+		}
+		if removedXMP {
+			flags &^= 0x04
+		}
+		result = append(result, patch{off: 20, n: 1, data: []byte{flags}})
+	}
+	return result
+}
+
+// tiffData EXIF的TIFF结构
+type tiffData struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// tiffEntry IFD中的一项
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// pos 该项在TIFF数据中的位置
+	pos int
+}
+
+// tiffTypeSizes 各数据类型的字节数
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// ParseExif 解析EXIF的TIFF数据，提取相机、拍摄时间、方向和是否包含GPS
+func ParseExif(b []byte) (*ExifData, error) {
+	t, ifd0, err := newTiffData(b)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := t.ifd(ifd0)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &ExifData{}
+	var dateTime, original, offset string
+	for _, e := range entries {
+		switch e.tag {
+		case tagMake:
+			data.Make = t.str(e)
+		case tagModel:
+			data.Model = t.str(e)
+		case tagOrientation:
+			data.Orientation = int(t.uint(e))
+		case tagDateTime:
+			dateTime = t.str(e)
+		case tagGPSIFD:
+			if gps, err := t.ifd(t.uint(e)); err == nil && len(gps) > 0 {
+				data.HasGPS = true
+			}
+		case tagExifIFD:
+			exif, err := t.ifd(t.uint(e))
+			if err != nil {
+				continue
+			}
+			for _, e := range exif {
+				switch e.tag {
+				case tagDateTimeOriginal:
+					original = t.str(e)
+				case tagOffsetTimeOrig:
+					offset = t.str(e)
+				}
+			}
+		}
+	}
+
+	// 优先使用拍摄时间，没有时区信息时按UTC处理
+	if original == "" {
+		original = dateTime
+	}
+	if original != "" {
+		layout, value := "2006:01:02 15:04:05", original
+		if offset != "" {
+			layout, value = layout+"-07:00", original+offset
+		}
+		if takenAt, err := time.Parse(layout, value); err == nil {
+			data.TakenAt = &takenAt
+		}
+	}
+
+	return data, nil
+}
+
+// newTiffData 解析TIFF头，返回第一个IFD的位置
+func newTiffData(b []byte) (*tiffData, uint32, error) {
+	if len(b) < 8 {
+		return nil, 0, fmt.Errorf("EXIF数据过短")
+	}
+	t := &tiffData{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, 0, fmt.Errorf("EXIF字节序错误")
+	}
+	if t.order.Uint16(b[2:]) != 42 {
+		return nil, 0, fmt.Errorf("EXIF标识错误")
+	}
+	return t, t.order.Uint32(b[4:]), nil
+}
+
+// ifd 读取IFD中的所有项
+func (t *tiffData) ifd(offset uint32) ([]tiffEntry, error) {
+	pos := int(offset)
+	if offset == 0 || pos+2 > len(t.b) {
+		return nil, fmt.Errorf("IFD位置错误")
+	}
+	count := int(t.order.Uint16(t.b[pos:]))
+	if pos+2+count*12 > len(t.b) {
+		return nil, fmt.Errorf("IFD长度错误")
+	}
+
+	entries := make([]tiffEntry, 0, count)
+	for i := 0; i < count; i++ {
+		p := pos + 2 + i*12
+		entries = append(entries, tiffEntry{
+			tag:   t.order.Uint16(t.b[p:]),
+			typ:   t.order.Uint16(t.b[p+2:]),
+			count: t.order.Uint32(t.b[p+4:]),
+			pos:   p,
+		})
+	}
+	return entries, nil
+}
+
+// value 项的数据，不超过4字节时保存在项内，否则为偏移量指向的位置
+// 返回数据在TIFF中的起止位置，数据越界时返回false
+func (t *tiffData) value(e tiffEntry) (int, int, bool) {
+	typeSize, ok := tiffTypeSizes[e.typ]
+	if !ok {
+		return 0, 0, false
+	}
+	n := uint64(typeSize) * uint64(e.count)
+	if n <= 4 {
+		return e.pos + 8, e.pos + 8 + int(n), true
+	}
+	start := uint64(t.order.Uint32(t.b[e.pos+8:]))
+	if start+n > uint64(len(t.b)) {
+		return 0, 0, false
+	}
+	return int(start), int(start + n), true
+}
+
+// str 读取ASCII类型的值
+func (t *tiffData) str(e tiffEntry) string {
+	start, end, ok := t.value(e)
+	if !ok || e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(t.b[start:end]), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// uint 读取SHORT或LONG类型的第一个值
+func (t *tiffData) uint(e tiffEntry) uint32 {
+	switch e.typ {
+	case 3:
+		return uint32(t.order.Uint16(t.b[e.pos+8:]))
+	case 4:
+		return t.order.Uint32(t.b[e.pos+8:])
+	}
+	return 0
+}
+
+// scrubGPS 原地清除GPS IFD及其引用的数据，不改变TIFF数据的长度和其他内容的位置
+// 返回是否修改了数据；存在GPS IFD但无法解析时返回错误，调用方应删除整段EXIF
+func scrubGPS(b []byte) (bool, error) {
+	t, ifd0, err := newTiffData(b)
+	if err != nil {
+		return false, err
+	}
+	entries, err := t.ifd(ifd0)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+
+	for _, e := range entries {
+		if e.tag != tagGPSIFD {
+			continue
+		}
+		offset := t.uint(e)
+		gps, err := t.ifd(offset)
+		if err != nil {
+			return false, err
+		}
+		for _, g := range gps {
+			if start, end, ok := t.value(g); ok {
+				clear(b[start:end])
+			}
+		}
+
+		// 清空所有项和下一个IFD的位置，GPS IFD变为空IFD
+		pos := int(offset)
+		end := min(pos+2+len(gps)*12+4, len(b))
+		clear(b[pos:end])
+		changed = true
+	}
+	return changed, nil
+}
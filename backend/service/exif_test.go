@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+// testXMP 带GPS属性的XMP数据包
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+	`<rdf:Description xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="31,14.1N" exif:GPSLongitude="121,28.5E"/>` +
+	`</rdf:RDF></x:xmpmeta>`
+
+// jpegSegment 生成JPEG的APPn段
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// webpChunk 生成WebP数据块，奇数长度补齐
+func webpChunk(fourcc string, payload []byte) []byte {
+	chunk := append([]byte(fourcc), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// applyTestPolicy 按策略处理元数据，返回处理后的文件内容
+func applyTestPolicy(t *testing.T, data []byte, format, policy string) []byte {
+	t.Helper()
+	upload, err := SpoolUpload(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upload.Close()
+
+	_, processed, err := applyExifPolicy(upload, &ImageInfo{Format: format}, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed == nil {
+		return data
+	}
+	defer processed.Close()
+	result, err := io.ReadAll(processed.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestStripGPSRemovesJPEGXMP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	extended := append([]byte("http://ns.adobe.com/xmp/extension/\x00"), make([]byte, 40)...)
+	extended = append(extended, testXMP...)
+
+	data := []byte{0xff, 0xd8}
+	data = append(data, jpegSegment(0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), testXMP...))...)
+	data = append(data, jpegSegment(0xe1, extended)...)
+	data = append(data, buf.Bytes()[2:]...)
+
+	if kept := applyTestPolicy(t, data, "jpeg", ExifKeep); !bytes.Equal(kept, data) {
+		t.Fatal("keep policy modified the file")
+	}
+
+	stripped := applyTestPolicy(t, data, "jpeg", ExifStripGPS)
+	if bytes.Contains(stripped, []byte("GPSLatitude")) {
+		t.Fatal("strip_gps kept the XMP GPS properties")
+	}
+	if !bytes.Equal(stripped, buf.Bytes()) {
+		t.Fatalf("strip_gps should remove exactly the XMP segments: %d bytes, want %d", len(stripped), buf.Len())
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStripGPSRemovesWebPXMP(t *testing.T) {
+	// VP8X标志中设置了XMP位
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x04
+	bitstream := webpChunk("VP8L", []byte{0x2f, 0, 0, 0, 0})
+
+	body := append([]byte("WEBP"), webpChunk("VP8X", vp8x)...)
+	body = append(body, bitstream...)
+	body = append(body, webpChunk("XMP ", []byte(testXMP))...)
+	data := append([]byte("RIFF\x00\x00\x00\x00"), body...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(body)))
+
+	stripped := applyTestPolicy(t, data, "webp", ExifStripGPS)
+	if bytes.Contains(stripped, []byte("GPSLatitude")) {
+		t.Fatal("strip_gps kept the XMP GPS properties")
+	}
+	if got := binary.LittleEndian.Uint32(stripped[4:]); int(got) != len(stripped)-8 {
+		t.Fatalf("RIFF size = %d, want %d", got, len(stripped)-8)
+	}
+	if flags := stripped[20]; flags&0x04 != 0 {
+		t.Fatalf("VP8X flags = %#x, XMP bit should be cleared", flags)
+	}
+	if !bytes.HasSuffix(stripped, bitstream) {
+		t.Fatal("image data should be kept")
+	}
+}
+
+// testExifTIFF 生成包含相机厂商和GPS IFD的TIFF数据，gpsOffset为GPS IFD指针的值
+func testExifTIFF(gpsOffset uint32) []byte {
+	le := binary.LittleEndian
+	b := []byte("II\x2a\x00\x08\x00\x00\x00")
+	// IFD0：Make和GPS IFD指针
+	b = le.AppendUint16(b, 2)
+	b = le.AppendUint16(b, tagMake)
+	b = le.AppendUint16(b, 2)
+	b = le.AppendUint32(b, 6)
+	b = le.AppendUint32(b, 8+2+2*12+4)
+	b = le.AppendUint16(b, tagGPSIFD)
+	b = le.AppendUint16(b, 4)
+	b = le.AppendUint32(b, 1)
+	b = le.AppendUint32(b, gpsOffset)
+	b = le.AppendUint32(b, 0)
+	b = append(b, "Canon\x00"...)
+	// GPS IFD：GPSLatitude，3个RATIONAL存放在IFD之后
+	gps := uint32(len(b))
+	b = le.AppendUint16(b, 1)
+	b = le.AppendUint16(b, 2)
+	b = le.AppendUint16(b, 5)
+	b = le.AppendUint32(b, 3)
+	b = le.AppendUint32(b, gps+2+12+4)
+	b = le.AppendUint32(b, 0)
+	for _, v := range []uint32{31, 1, 14, 1, 7, 1} {
+		b = le.AppendUint32(b, v)
+	}
+	return b
+}
+
+// testExifJPEG 生成带EXIF段的JPEG
+func testExifJPEG(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{0xff, 0xd8}
+	data = append(data, jpegSegment(0xe1, append([]byte("Exif\x00\x00"), tiff...))...)
+	return append(data, buf.Bytes()[2:]...)
+}
+
+func TestStripGPSScrubsGPSIFD(t *testing.T) {
+	tiff := testExifTIFF(8 + 2 + 2*12 + 4 + 6)
+	data := testExifJPEG(t, tiff)
+
+	stripped := applyTestPolicy(t, data, "jpeg", ExifStripGPS)
+	if len(stripped) != len(data) {
+		t.Fatalf("GPS should be cleared in place: %d bytes, want %d", len(stripped), len(data))
+	}
+	exif := stripped[2+4+6 : 2+4+6+len(tiff)]
+	parsed, err := ParseExif(exif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.HasGPS || parsed.Make != "Canon" {
+		t.Fatalf("after strip_gps: %+v, want Make kept and no GPS", parsed)
+	}
+	if bytes.Contains(exif, []byte{31, 0, 0, 0, 1, 0, 0, 0, 14}) {
+		t.Fatal("GPS values were not cleared")
+	}
+}
+
+func TestStripGPSDropsUnparsableGPSIFD(t *testing.T) {
+	// GPS IFD指针超出EXIF数据范围，无法确认GPS已被清除
+	data := testExifJPEG(t, testExifTIFF(0xfff0))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stripped := applyTestPolicy(t, data, "jpeg", ExifStripGPS)
+	if !bytes.Equal(stripped, buf.Bytes()) {
+		t.Fatalf("EXIF segment with an unparsable GPS IFD should be removed: %d bytes, want %d", len(stripped), buf.Len())
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/telegram-photo/model"
+)
+
+const (
+	// fileInfoBatchSize 补全任务每批查询的文件数
+	fileInfoBatchSize = 50
+	// fileInfoTimeout 下载单个文件的超时时长
+	fileInfoTimeout = 5 * time.Minute
+)
+
+// ErrBackfillRunning 补全任务正在运行
+var ErrBackfillRunning = errors.New("补全任务正在运行")
+
+// FileInfoBackfillStatus 补全文件信息任务的进度
+type FileInfoBackfillStatus struct {
+	Running    bool       `json:"running"`
+	Total      int64      `json:"total"`
+	Processed  int        `json:"processed"`
+	Updated    int        `json:"updated"`
+	Failed     int        `json:"failed"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+var fileInfoBackfill struct {
+	mu     sync.Mutex
+	status FileInfoBackfillStatus
+}
+
+// StartFileInfoBackfill 启动后台任务，下载大小、SHA-256或感知哈希未填写的旧文件并补全
+// 同一时间只运行一个任务，已在运行时返回ErrBackfillRunning
+func StartFileInfoBackfill() (FileInfoBackfillStatus, error) {
+	fileInfoBackfill.mu.Lock()
+	defer fileInfoBackfill.mu.Unlock()
+
+	if fileInfoBackfill.status.Running {
+		return fileInfoBackfill.status, ErrBackfillRunning
+	}
+
+	total, err := model.CountFilesWithoutInfo()
+	if err != nil {
+		return fileInfoBackfill.status, fmt.Errorf("统计待补全文件失败: %w", err)
+	}
+
+	now := time.Now()
+	fileInfoBackfill.status = FileInfoBackfillStatus{Running: true, Total: total, StartedAt: &now}
+	go runFileInfoBackfill()
+
+	return fileInfoBackfill.status, nil
+}
+
+// StartSHA256Backfill 存在未填写SHA-256的旧文件时启动补全任务，服务启动并初始化存储后端后调用
+// 补全前这些文件只能在上传相同内容时下载校验后复用，补全后按SHA-256去重
+func StartSHA256Backfill() {
+	count, err := model.CountFilesWithoutSHA256()
+	if err != nil {
+		log.Printf("统计未填写SHA-256的文件失败: %v", err)
+		return
+	}
+	if count == 0 {
+		return
+	}
+
+	log.Printf("有%d个文件未填写SHA-256，开始补全", count)
+	if _, err := StartFileInfoBackfill(); err != nil && !errors.Is(err, ErrBackfillRunning) {
+		log.Printf("启动SHA-256补全任务失败: %v", err)
+	}
+}
+
+// FileInfoBackfillProgress 获取补全任务的进度
+func FileInfoBackfillProgress() FileInfoBackfillStatus {
+	fileInfoBackfill.mu.Lock()
+	defer fileInfoBackfill.mu.Unlock()
+	return fileInfoBackfill.status
+}
+
+// runFileInfoBackfill 按ID顺序分批处理，失败的文件跳过，下次启动任务时重新处理
+func runFileInfoBackfill() {
+	var lastID uint
+	for {
+		files, err := model.GetFilesWithoutInfo(lastID, fileInfoBatchSize)
+		if err != nil {
+			fileInfoBackfill.mu.Lock()
+			fileInfoBackfill.status.LastError = fmt.Sprintf("查询待补全文件失败: %v", err)
+			fileInfoBackfill.mu.Unlock()
+			break
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for i := range files {
+			lastID = files[i].ID
+			err := backfillFileInfo(&files[i])
+			if err != nil {
+				log.Printf("补全文件信息失败 - 文件ID: %d, 错误: %v", files[i].ID, err)
+			}
+			recordFileInfoBackfill(err)
+		}
+	}
+
+	fileInfoBackfill.mu.Lock()
+	now := time.Now()
+	fileInfoBackfill.status.Running = false
+	fileInfoBackfill.status.FinishedAt = &now
+	status := fileInfoBackfill.status
+	fileInfoBackfill.mu.Unlock()
+
+	log.Printf("文件信息补全完成 - 处理: %d, 更新: %d, 失败: %d", status.Processed, status.Updated, status.Failed)
+}
+
+// recordFileInfoBackfill 记录一个文件的处理结果
+func recordFileInfoBackfill(err error) {
+	fileInfoBackfill.mu.Lock()
+	defer fileInfoBackfill.mu.Unlock()
+
+	fileInfoBackfill.status.Processed++
+	if err != nil {
+		fileInfoBackfill.status.LastError = err.Error()
+		fileInfoBackfill.status.Failed++
+	} else {
+		fileInfoBackfill.status.Updated++
+	}
+}
+
+// backfillFileInfo 下载文件并根据内容填写大小、尺寸、类型、感知哈希和SHA-256
+// 无法识别为图片时不填写尺寸、类型和感知哈希；以photo模式上传的文件已被Telegram压缩，无法得到上传内容的SHA-256，不填写
+func backfillFileInfo(file *model.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fileInfoTimeout)
+	defer cancel()
+
+	body, err := OpenStoredFile(ctx, file)
+	if err != nil {
+		return err
+	}
+	upload, err := SpoolUpload(body, 0)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	defer upload.Close()
+
+	if upload.Size == 0 {
+		return fmt.Errorf("文件内容为空")
+	}
+
+	fields := map[string]interface{}{}
+	if file.Size == 0 {
+		fields["size"] = upload.Size
+		if info, err := IdentifyImage(upload.Reader(), upload.Size); err == nil {
+			fields["width"] = info.Width
+			fields["height"] = info.Height
+			fields["mime_type"] = info.MimeType
+		}
+	}
+
+	var failure error
+	if file.PHash == "" {
+		if phash, err := perceptualHashLimited(ctx, upload.Reader()); err == nil {
+			fields["phash"] = phash
+		} else {
+			failure = fmt.Errorf("计算感知哈希失败: %w", err)
+		}
+	}
+
+	if len(fields) > 0 {
+		if err := model.UpdateFile(file.ID, fields); err != nil {
+			return err
+		}
+	}
+
+	// 按下载得到的内容填写，已有相同内容的文件时记录为重复文件
+	if file.SHA256Hash == "" && file.UploadMode != UploadModePhoto && file.DuplicateOf == 0 {
+		if err := model.SetFileSHA256(file, upload.SHA256Hash); err != nil {
+			return err
+		}
+	}
+	return failure
+}
+
+// OpenStoredFile 从文件所在的存储后端读取原图，分块存储的文件按顺序拼接
+func OpenStoredFile(ctx context.Context, file *model.File) (io.ReadCloser, error) {
+	st, err := GetFileStorage(file.Storage, file.TelegramBot)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := model.GetFileChunks(file.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		object, err := st.Get(ctx, file.TelegramFileID)
+		if err != nil {
+			return nil, err
+		}
+		return object.Body, nil
+	}
+
+	keys := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		keys = append(keys, chunk.TelegramFileID)
+	}
+	return NewChunkReader(ctx, st, keys), nil
+}
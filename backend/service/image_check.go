@@ -0,0 +1,755 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// ErrInvalidImage 文件不是允许上传的图片格式，或图片结构损坏
+var ErrInvalidImage = errors.New("不支持的图片文件")
+
+// ImageInfo 根据文件内容识别的图片信息
+type ImageInfo struct {
+	Format   string
+	MimeType string
+	Width    int
+	Height   int
+	// Passthrough 为upload.passthrough_formats中的视频或RAW等格式，只按文件头识别类型，尺寸为0
+	Passthrough bool
+	// DataEnd 大于0时图片数据在该位置结束，之后附加的内容需要删除
+	DataEnd int64
+}
+
+// imageFormat 可识别的图片格式
+type imageFormat struct {
+	name string
+	mime string
+	exts []string
+	// magic 判断文件开头是否为该格式
+	magic func(head []byte) bool
+	// decodeConfig 解析图片头，获取尺寸
+	decodeConfig func(r io.Reader) (image.Config, error)
+	// end 按容器结构计算图片数据结束的位置，为空时不检查
+	end func(r io.ReaderAt, size int64) (int64, error)
+}
+
+// imageFormats 可识别的图片格式，通过upload.allowed_formats配置允许上传哪些格式
+var imageFormats = []imageFormat{
+	{
+		name:         "jpeg",
+		mime:         "image/jpeg",
+		exts:         []string{".jpg", ".jpeg"},
+		magic:        func(head []byte) bool { return bytes.HasPrefix(head, []byte("\xff\xd8\xff")) },
+		decodeConfig: jpeg.DecodeConfig,
+		end:          jpegEnd,
+	},
+	{
+		name:         "png",
+		mime:         "image/png",
+		exts:         []string{".png"},
+		magic:        func(head []byte) bool { return bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")) },
+		decodeConfig: png.DecodeConfig,
+		end:          pngEnd,
+	},
+	{
+		name: "gif",
+		mime: "image/gif",
+		exts: []string{".gif"},
+		magic: func(head []byte) bool {
+			return bytes.HasPrefix(head, []byte("GIF87a")) || bytes.HasPrefix(head, []byte("GIF89a"))
+		},
+		decodeConfig: gif.DecodeConfig,
+		end:          gifEnd,
+	},
+	{
+		name: "webp",
+		mime: "image/webp",
+		exts: []string{".webp"},
+		magic: func(head []byte) bool {
+			return len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && string(head[8:12]) == "WEBP"
+		},
+		decodeConfig: webp.DecodeConfig,
+		end:          webpEnd,
+	},
+	{
+		name:         "bmp",
+		mime:         "image/bmp",
+		exts:         []string{".bmp"},
+		magic:        func(head []byte) bool { return bytes.HasPrefix(head, []byte("BM")) },
+		decodeConfig: bmp.DecodeConfig,
+		end:          bmpEnd,
+	},
+	{
+		name: "tiff",
+		mime: "image/tiff",
+		exts: []string{".tif", ".tiff"},
+		magic: func(head []byte) bool {
+			return bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*"))
+		},
+		decodeConfig: tiff.DecodeConfig,
+		end:          tiffEnd,
+	},
+}
+
+// passthroughFormats 不做图片校验、只按文件头识别类型的格式，通过upload.passthrough_formats配置允许上传哪些格式
+// 这些文件原样保存，不解析尺寸、不处理元数据、不计算感知哈希
+var passthroughFormats = []imageFormat{
+	{name: "mp4", mime: "video/mp4", exts: []string{".mp4", ".m4v"}, magic: func(head []byte) bool { return isoBrand(head) == "mp4" }},
+	{name: "mov", mime: "video/quicktime", exts: []string{".mov"}, magic: func(head []byte) bool { return isoBrand(head) == "mov" }},
+	{name: "heic", mime: "image/heic", exts: []string{".heic", ".heif"}, magic: func(head []byte) bool { return isoBrand(head) == "heic" }},
+	{name: "avif", mime: "image/avif", exts: []string{".avif"}, magic: func(head []byte) bool { return isoBrand(head) == "avif" }},
+	{name: "cr3", mime: "image/x-canon-cr3", exts: []string{".cr3"}, magic: func(head []byte) bool { return isoBrand(head) == "cr3" }},
+	{
+		name: "webm",
+		mime: "video/webm",
+		exts: []string{".webm"},
+		magic: func(head []byte) bool {
+			return bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")) && bytes.Contains(head[:min(len(head), 64)], []byte("webm"))
+		},
+	},
+	{
+		name: "mkv",
+		mime: "video/x-matroska",
+		exts: []string{".mkv"},
+		magic: func(head []byte) bool {
+			return bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")) && !bytes.Contains(head[:min(len(head), 64)], []byte("webm"))
+		},
+	},
+	{
+		name: "avi",
+		mime: "video/x-msvideo",
+		exts: []string{".avi"},
+		magic: func(head []byte) bool {
+			return len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && string(head[8:12]) == "AVI "
+		},
+	},
+	{
+		name: "cr2",
+		mime: "image/x-canon-cr2",
+		exts: []string{".cr2"},
+		magic: func(head []byte) bool {
+			return bytes.HasPrefix(head, []byte("II*\x00")) && len(head) >= 11 && string(head[8:11]) == "CR\x02"
+		},
+	},
+	{name: "raf", mime: "image/x-fuji-raf", exts: []string{".raf"}, magic: func(head []byte) bool { return bytes.HasPrefix(head, []byte("FUJIFILMCCD-RAW")) }},
+	{
+		name: "orf",
+		mime: "image/x-olympus-orf",
+		exts: []string{".orf"},
+		magic: func(head []byte) bool {
+			return bytes.HasPrefix(head, []byte("IIRO")) || bytes.HasPrefix(head, []byte("IIRS")) || bytes.HasPrefix(head, []byte("MMOR"))
+		},
+	},
+	{name: "rw2", mime: "image/x-panasonic-rw2", exts: []string{".rw2"}, magic: func(head []byte) bool { return bytes.HasPrefix(head, []byte("IIU\x00")) }},
+	{name: "dng", mime: "image/x-adobe-dng", exts: []string{".dng"}, magic: func(head []byte) bool { return tiffRaw(head) == "dng" }},
+	{name: "nef", mime: "image/x-nikon-nef", exts: []string{".nef"}, magic: func(head []byte) bool { return tiffRaw(head) == "nef" }},
+	{name: "arw", mime: "image/x-sony-arw", exts: []string{".arw"}, magic: func(head []byte) bool { return tiffRaw(head) == "arw" }},
+}
+
+// isoBrand 按ISO BMFF文件开头ftyp盒子的主品牌区分格式，不是ISO BMFF文件时返回空
+func isoBrand(head []byte) string {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return ""
+	}
+	switch string(head[8:12]) {
+	case "qt  ":
+		return "mov"
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return "heic"
+	case "avif", "avis":
+		return "avif"
+	case "crx ":
+		return "cr3"
+	}
+	return "mp4"
+}
+
+// tagDNGVersion DNG文件IFD0中的版本标签
+const tagDNGVersion = 0xc612
+
+// tiffRaw 识别基于TIFF结构的RAW格式，DNG按IFD0中的版本标签判断，NEF和ARW按相机厂商判断
+// IFD0不在文件开头读取的范围内时无法识别，返回空
+func tiffRaw(head []byte) string {
+	t, ifd0, err := newTiffData(head)
+	if err != nil {
+		return ""
+	}
+	entries, err := t.ifd(ifd0)
+	if err != nil {
+		return ""
+	}
+	var cameraMake string
+	for _, e := range entries {
+		switch e.tag {
+		case tagDNGVersion:
+			return "dng"
+		case tagMake:
+			cameraMake = strings.ToUpper(t.str(e))
+		}
+	}
+	switch {
+	case strings.HasPrefix(cameraMake, "NIKON"):
+		return "nef"
+	case strings.HasPrefix(cameraMake, "SONY"):
+		return "arw"
+	}
+	return ""
+}
+
+// allowedImageFormat 是否允许上传该格式
+func allowedImageFormat(name string) bool {
+	formats := viper.GetStringSlice("upload.allowed_formats")
+	if len(formats) == 0 {
+		formats = []string{"jpeg", "png", "gif", "webp"}
+	}
+	return containsFormat(formats, name)
+}
+
+// detectPassthrough 识别upload.passthrough_formats中允许的格式，未配置或不是这些格式时返回nil
+func detectPassthrough(head []byte) *imageFormat {
+	formats := viper.GetStringSlice("upload.passthrough_formats")
+	if len(formats) == 0 {
+		return nil
+	}
+	for i := range passthroughFormats {
+		if passthroughFormats[i].magic(head) && containsFormat(formats, passthroughFormats[i].name) {
+			return &passthroughFormats[i]
+		}
+	}
+	return nil
+}
+
+// containsFormat 配置的格式列表中是否包含该格式，忽略大小写，jpg视为jpeg
+func containsFormat(formats []string, name string) bool {
+	for _, format := range formats {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if format == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectImage 根据文件内容识别图片格式，不信任文件名和客户端声明的类型
+// 完整解析图片头，并检查容器结构之后没有附加其他内容，拒绝伪装成图片的文件和多格式混合文件
+// 附加的内容（如动态照片中的视频）在upload.strip_trailing_data开启时记录在DataEnd中，由调用方删除
+// upload.passthrough_formats中的格式只按文件头识别，不做以上检查
+func DetectImage(r io.ReaderAt, size int64) (*ImageInfo, error) {
+	head, err := readHead(r)
+	if err != nil {
+		return nil, err
+	}
+	if format := detectPassthrough(head); format != nil {
+		return &ImageInfo{Format: format.name, MimeType: format.mime, Passthrough: true}, nil
+	}
+
+	format, config, err := identifyImage(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if !allowedImageFormat(format.name) {
+		return nil, fmt.Errorf("%w: 不允许上传%s格式", ErrInvalidImage, format.name)
+	}
+
+	info := &ImageInfo{
+		Format:   format.name,
+		MimeType: format.mime,
+		Width:    config.Width,
+		Height:   config.Height,
+	}
+	if format.end != nil && !viper.GetBool("upload.allow_trailing_data") {
+		end, err := format.end(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		if end != size {
+			if !viper.GetBool("upload.strip_trailing_data") {
+				return nil, fmt.Errorf("%w: 图片数据之后存在多余内容", ErrInvalidImage)
+			}
+			info.DataEnd = end
+		}
+	}
+	return info, nil
+}
+
+// IdentifyImage 根据文件内容识别图片格式和尺寸，不检查上传限制，用于已保存的文件
+func IdentifyImage(r io.ReaderAt, size int64) (*ImageInfo, error) {
+	format, config, err := identifyImage(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageInfo{
+		Format:   format.name,
+		MimeType: format.mime,
+		Width:    config.Width,
+		Height:   config.Height,
+	}, nil
+}
+
+// identifyImage 根据文件开头识别格式并解析图片头
+func identifyImage(r io.ReaderAt, size int64) (*imageFormat, image.Config, error) {
+	head, err := readHead(r)
+	if err != nil {
+		return nil, image.Config{}, err
+	}
+
+	var format *imageFormat
+	for i := range imageFormats {
+		if imageFormats[i].magic(head) {
+			format = &imageFormats[i]
+			break
+		}
+	}
+	if format == nil {
+		return nil, image.Config{}, fmt.Errorf("%w: 无法识别的文件类型", ErrInvalidImage)
+	}
+
+	config, err := format.decodeConfig(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, image.Config{}, fmt.Errorf("%w: 图片头解析失败: %v", ErrInvalidImage, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, image.Config{}, fmt.Errorf("%w: 图片尺寸无效", ErrInvalidImage)
+	}
+	return format, config, nil
+}
+
+// headSize 识别格式时读取的文件开头长度，需要包含TIFF结构RAW文件的IFD0
+const headSize = 4096
+
+// readHead 读取文件开头用于识别格式
+func readHead(r io.ReaderAt) ([]byte, error) {
+	head := make([]byte, headSize)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// ImageFilename 按识别出的格式修正文件扩展名，文件名为空时使用image
+func ImageFilename(filename string, info *ImageInfo) string {
+	name := filepath.Base(filename)
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "image"
+	}
+
+	for _, format := range append(imageFormats[:len(imageFormats):len(imageFormats)], passthroughFormats...) {
+		if format.name != info.Format {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		for _, e := range format.exts {
+			if ext == e {
+				return name
+			}
+		}
+		return strings.TrimSuffix(name, filepath.Ext(name)) + format.exts[0]
+	}
+	return name
+}
+
+// jpegEnd 依次跳过JPEG的各个段和熵编码数据，返回EOI标记结束的位置
+// 只查看文件末尾是否为EOI无法发现拼接在后面的另一张JPEG，EXIF中的缩略图也有自己的EOI
+// 部分设备在EOI之后填充少量0字节，视为图片的一部分
+func jpegEnd(r io.ReaderAt, size int64) (int64, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	offset := int64(0)
+	readByte := func() (byte, error) {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("JPEG缺少结束标记")
+		}
+		offset++
+		return b, nil
+	}
+
+	if _, err := br.Discard(2); err != nil {
+		return 0, fmt.Errorf("JPEG缺少开始标记")
+	}
+	offset = 2
+
+	// pending 为扫描熵编码数据时已经读到的标记
+	pending := byte(0)
+	for {
+		marker := pending
+		pending = 0
+		if marker == 0 {
+			// 标记以0xFF开头，之前可以有多个0xFF填充
+			b, err := readByte()
+			if err != nil {
+				return 0, err
+			}
+			if b != 0xff {
+				return 0, fmt.Errorf("JPEG段结构错误")
+			}
+			for marker = 0xff; marker == 0xff; {
+				if marker, err = readByte(); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		switch {
+		case marker == 0xd9:
+			return jpegPadding(r, offset, size)
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			// TEM和RST标记没有长度字段
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return 0, fmt.Errorf("JPEG段不完整")
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 {
+			return 0, fmt.Errorf("JPEG段长度错误")
+		}
+		if _, err := br.Discard(n - 2); err != nil {
+			return 0, fmt.Errorf("JPEG段不完整")
+		}
+		offset += int64(n)
+
+		if marker != 0xda {
+			continue
+		}
+
+		// SOS之后为熵编码数据，直到遇到0xFF后跟非0且非RST的字节
+		for pending == 0 {
+			b, err := readByte()
+			if err != nil {
+				return 0, err
+			}
+			for b == 0xff {
+				if b, err = readByte(); err != nil {
+					return 0, err
+				}
+				if b != 0x00 && b != 0xff && (b < 0xd0 || b > 0xd7) {
+					pending = b
+				}
+			}
+		}
+	}
+}
+
+// jpegPadding EOI之后只有少量0字节时视为文件结束
+func jpegPadding(r io.ReaderAt, end, size int64) (int64, error) {
+	if size-end > 64 {
+		return end, nil
+	}
+	tail := make([]byte, size-end)
+	if _, err := r.ReadAt(tail, end); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if len(bytes.TrimRight(tail, "\x00")) > 0 {
+		return end, nil
+	}
+	return size, nil
+}
+
+// pngEnd 依次校验PNG的每个数据块，返回IEND块结束的位置
+func pngEnd(r io.ReaderAt, size int64) (int64, error) {
+	offset := int64(8)
+	header := make([]byte, 8)
+	for {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return 0, fmt.Errorf("PNG数据块不完整")
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		if offset+12+length > size {
+			return 0, fmt.Errorf("PNG数据块长度错误")
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		if _, err := io.Copy(crc, io.NewSectionReader(r, offset+8, length)); err != nil {
+			return 0, err
+		}
+		sum := make([]byte, 4)
+		if _, err := r.ReadAt(sum, offset+8+length); err != nil {
+			return 0, err
+		}
+		if binary.BigEndian.Uint32(sum) != crc.Sum32() {
+			return 0, fmt.Errorf("PNG数据块%q校验失败", header[4:])
+		}
+
+		offset += 12 + length
+		if string(header[4:]) == "IEND" {
+			return offset, nil
+		}
+	}
+}
+
+// gifEnd 依次跳过GIF的颜色表、扩展块和图像数据，返回结束标记0x3B之后的位置
+func gifEnd(r io.ReaderAt, size int64) (int64, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	offset := int64(0)
+	skip := func(n int) error {
+		if _, err := br.Discard(n); err != nil {
+			return fmt.Errorf("GIF数据不完整")
+		}
+		offset += int64(n)
+		return nil
+	}
+	readByte := func() (byte, error) {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("GIF缺少结束标记")
+		}
+		offset++
+		return b, nil
+	}
+	// skipSubBlocks 跳过以长度为0的块结束的数据子块
+	skipSubBlocks := func() error {
+		for {
+			n, err := readByte()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return nil
+			}
+			if err := skip(int(n)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 文件头和逻辑屏幕描述符
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, fmt.Errorf("GIF数据不完整")
+	}
+	offset = 13
+	if header[10]&0x80 != 0 {
+		if err := skip(3 << (header[10]&0x07 + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	for {
+		b, err := readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case 0x3b:
+			return offset, nil
+		case 0x21:
+			// 扩展块：标签之后为数据子块
+			if _, err := readByte(); err != nil {
+				return 0, err
+			}
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x2c:
+			// 图像描述符，可能带局部颜色表，之后为LZW最小码长和数据子块
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(br, desc); err != nil {
+				return 0, fmt.Errorf("GIF数据不完整")
+			}
+			offset += 9
+			if desc[8]&0x80 != 0 {
+				if err := skip(3 << (desc[8]&0x07 + 1)); err != nil {
+					return 0, err
+				}
+			}
+			if err := skip(1); err != nil {
+				return 0, err
+			}
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("GIF块类型错误: 0x%02x", b)
+		}
+	}
+}
+
+// webpEnd RIFF头中记录的长度
+func webpEnd(r io.ReaderAt, size int64) (int64, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header[4:]))
+	return 8 + length + length%2, nil
+}
+
+// bmpEnd BMP文件头中记录的文件大小，部分程序写入0时不检查
+func bmpEnd(r io.ReaderAt, size int64) (int64, error) {
+	header := make([]byte, 6)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if length := int64(binary.LittleEndian.Uint32(header[2:])); length != 0 {
+		return length, nil
+	}
+	return size, nil
+}
+
+// TIFF中需要跟随的标签
+const (
+	tiffStripOffsets    = 273
+	tiffStripByteCounts = 279
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSubIFDs         = 330
+	tiffExifIFD         = 34665
+	tiffGPSIFD          = 34853
+	tiffInteropIFD      = 40965
+)
+
+// tiffEnd 遍历TIFF的所有IFD，返回IFD、标签数据和图像条带/分块中最靠后的结束位置
+// TIFF的数据可以按任意顺序存放，只能确认文件末尾没有未被引用的内容
+func tiffEnd(r io.ReaderAt, size int64) (int64, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, fmt.Errorf("TIFF文件头不完整")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	end := int64(8)
+	extend := func(off, n int64) error {
+		if off < 0 || n < 0 || off+n > size {
+			return fmt.Errorf("TIFF数据超出文件范围")
+		}
+		end = max(end, off+n)
+		return nil
+	}
+
+	// readValues 读取SHORT或LONG类型的数组
+	readValues := func(typ uint16, count, off int64) ([]int64, error) {
+		width := int64(tiffTypeSizes[typ])
+		if typ != 3 && typ != 4 || count > size/width {
+			return nil, fmt.Errorf("TIFF标签格式错误")
+		}
+		buf := make([]byte, count*width)
+		if _, err := r.ReadAt(buf, off); err != nil {
+			return nil, fmt.Errorf("TIFF数据超出文件范围")
+		}
+		values := make([]int64, count)
+		for i := range values {
+			if typ == 3 {
+				values[i] = int64(order.Uint16(buf[i*2:]))
+			} else {
+				values[i] = int64(order.Uint32(buf[i*4:]))
+			}
+		}
+		return values, nil
+	}
+
+	queue := []int64{int64(order.Uint32(header[4:]))}
+	seen := make(map[int64]bool)
+	for len(queue) > 0 {
+		ifd := queue[0]
+		queue = queue[1:]
+		if ifd == 0 || seen[ifd] {
+			continue
+		}
+		if len(seen) >= 1000 {
+			return 0, fmt.Errorf("TIFF的IFD过多")
+		}
+		seen[ifd] = true
+
+		count := make([]byte, 2)
+		if _, err := r.ReadAt(count, ifd); err != nil {
+			return 0, fmt.Errorf("TIFF数据超出文件范围")
+		}
+		n := int64(order.Uint16(count))
+		entries := make([]byte, n*12+4)
+		if _, err := r.ReadAt(entries, ifd+2); err != nil {
+			return 0, fmt.Errorf("TIFF数据超出文件范围")
+		}
+		if err := extend(ifd, 2+n*12+4); err != nil {
+			return 0, err
+		}
+
+		offsets := map[uint16][]int64{}
+		for i := int64(0); i < n; i++ {
+			entry := entries[i*12 : i*12+12]
+			tag := order.Uint16(entry)
+			typ := order.Uint16(entry[2:])
+			valueCount := int64(order.Uint32(entry[4:]))
+
+			typeSize, ok := tiffTypeSizes[typ]
+			width := int64(typeSize)
+			if !ok {
+				// 未知类型无法计算长度，读取器同样会忽略
+				continue
+			}
+			if valueCount > size/width {
+				return 0, fmt.Errorf("TIFF标签长度错误")
+			}
+			// 不超过4字节的值直接存放在标签中
+			valueOff := ifd + 2 + i*12 + 8
+			if valueCount*width > 4 {
+				valueOff = int64(order.Uint32(entry[8:]))
+				if err := extend(valueOff, valueCount*width); err != nil {
+					return 0, err
+				}
+			}
+
+			switch tag {
+			case tiffStripOffsets, tiffStripByteCounts, tiffTileOffsets, tiffTileByteCounts:
+				values, err := readValues(typ, valueCount, valueOff)
+				if err != nil {
+					return 0, err
+				}
+				offsets[tag] = values
+			case tiffSubIFDs, tiffExifIFD, tiffGPSIFD, tiffInteropIFD:
+				if typ == 4 || typ == 13 {
+					values, err := readValues(4, valueCount, valueOff)
+					if err != nil {
+						return 0, err
+					}
+					queue = append(queue, values...)
+				}
+			}
+		}
+
+		for _, pair := range [][2]uint16{{tiffStripOffsets, tiffStripByteCounts}, {tiffTileOffsets, tiffTileByteCounts}} {
+			starts, lengths := offsets[pair[0]], offsets[pair[1]]
+			if len(starts) != len(lengths) {
+				return 0, fmt.Errorf("TIFF图像数据位置错误")
+			}
+			for i := range starts {
+				if err := extend(starts[i], lengths[i]); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		queue = append(queue, int64(order.Uint32(entries[n*12:])))
+	}
+
+	// 数据按字对齐时末尾可能有1字节填充
+	if end%2 == 1 && size == end+1 {
+		return size, nil
+	}
+	return end, nil
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/image/tiff"
+)
+
+// testImage 生成带渐变的测试图片，保证编码后的数据中有0xFF字节
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 5), uint8(x * y), 0xff})
+		}
+	}
+	return img
+}
+
+// useAllowedFormats 临时修改允许上传的格式
+func useAllowedFormats(t *testing.T, formats ...string) {
+	t.Helper()
+	useConfig(t, "upload.allowed_formats", formats)
+}
+
+// useConfig 临时修改配置项，测试结束后恢复
+func useConfig(t *testing.T, key string, value interface{}) {
+	t.Helper()
+	saved := viper.Get(key)
+	viper.Set(key, value)
+	t.Cleanup(func() { viper.Set(key, saved) })
+}
+
+// encodeTestImages 各格式编码的测试图片
+func encodeTestImages(t *testing.T) map[string][]byte {
+	t.Helper()
+	img := testImage()
+	images := map[string][]byte{}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	images["jpeg"] = append([]byte(nil), buf.Bytes()...)
+
+	// 在SOI之后插入带缩略图的APP1段，缩略图中有自己的EOI
+	thumb := append([]byte{0xff, 0xd8, 0xff, 0xd9}, "Exif-thumbnail"...)
+	app1 := append([]byte{0xff, 0xe1, 0, byte(len(thumb) + 2)}, thumb...)
+	withThumb := append([]byte{0xff, 0xd8}, app1...)
+	images["jpeg-thumbnail"] = append(withThumb, buf.Bytes()[2:]...)
+
+	buf.Reset()
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	images["png"] = append([]byte(nil), buf.Bytes()...)
+
+	buf.Reset()
+	palette := color.Palette{color.Black, color.White, color.NRGBA{0xff, 0, 0, 0xff}, color.NRGBA{0, 0, 0xff, 0xff}}
+	frames := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 32, 32), palette)
+		for p := range frame.Pix {
+			frame.Pix[p] = uint8((p + i) % len(palette))
+		}
+		frames.Image = append(frames.Image, frame)
+		frames.Delay = append(frames.Delay, 10)
+	}
+	if err := gif.EncodeAll(&buf, frames); err != nil {
+		t.Fatal(err)
+	}
+	images["gif"] = append([]byte(nil), buf.Bytes()...)
+
+	for name, compression := range map[string]tiff.CompressionType{"tiff": tiff.Uncompressed, "tiff-deflate": tiff.Deflate} {
+		buf.Reset()
+		if err := tiff.Encode(&buf, img, &tiff.Options{Compression: compression}); err != nil {
+			t.Fatal(err)
+		}
+		images[name] = append([]byte(nil), buf.Bytes()...)
+	}
+	return images
+}
+
+func TestDetectImageTrailingData(t *testing.T) {
+	useAllowedFormats(t, "jpeg", "png", "gif", "tiff")
+	useConfig(t, "upload.strip_trailing_data", false)
+	images := encodeTestImages(t)
+
+	for name, data := range images {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DetectImage(bytes.NewReader(data), int64(len(data))); err != nil {
+				t.Fatalf("valid image rejected: %v", err)
+			}
+
+			// 附加的内容以各格式的结束标记结尾，只检查文件末尾时会被放过
+			for _, suffix := range [][]byte{
+				[]byte("PK\x03\x04 trailing archive"),
+				images["jpeg"],
+				append([]byte("payload"), 0x3b),
+			} {
+				polyglot := append(append([]byte(nil), data...), suffix...)
+				_, err := DetectImage(bytes.NewReader(polyglot), int64(len(polyglot)))
+				if !errors.Is(err, ErrInvalidImage) {
+					t.Fatalf("trailing %q: got %v, want ErrInvalidImage", suffix[:4], err)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectImageStripsTrailingData(t *testing.T) {
+	useAllowedFormats(t, "jpeg", "png", "gif", "tiff")
+	useConfig(t, "upload.strip_trailing_data", true)
+
+	for name, data := range encodeTestImages(t) {
+		t.Run(name, func(t *testing.T) {
+			// 动态照片在图片数据之后附加视频
+			motion := append(append([]byte(nil), data...), "\x00\x00\x00\x18ftypmp42 motion video"...)
+			upload, err := SpoolUpload(bytes.NewReader(motion), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer upload.Close()
+
+			info, err := upload.DetectImage()
+			if err != nil {
+				t.Fatalf("image with trailing data rejected: %v", err)
+			}
+			if info.DataEnd != int64(len(data)) {
+				t.Fatalf("DataEnd = %d, want %d", info.DataEnd, len(data))
+			}
+
+			truncated, err := upload.truncate(info.DataEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer truncated.Close()
+			content, err := io.ReadAll(truncated.Reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, data) {
+				t.Fatalf("truncated to %d bytes, want the %d image bytes", len(content), len(data))
+			}
+			if info, err := DetectImage(truncated.Reader(), truncated.Size); err != nil || info.DataEnd != 0 {
+				t.Fatalf("truncated image: %+v, %v", info, err)
+			}
+		})
+	}
+}
+
+func TestDetectPassthrough(t *testing.T) {
+	useAllowedFormats(t, "jpeg", "tiff")
+	dng := testExifTIFF(0)
+	dng[8+2+12] = 0x12
+	dng[8+2+12+1] = 0xc6
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isommp41"), "mp4"},
+		{"mov", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  "), "mov"},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), "heic"},
+		{"cr3", []byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01crx isom"), "cr3"},
+		{"webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), "webm"},
+		{"mkv", []byte("\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska"), "mkv"},
+		{"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), "avi"},
+		{"cr2", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), "cr2"},
+		{"raf", []byte("FUJIFILMCCD-RAW 0201FF383501"), "raf"},
+		{"orf", []byte("IIRO\x08\x00\x00\x00"), "orf"},
+		{"rw2", []byte("IIU\x00\x08\x00\x00\x00"), "rw2"},
+		{"dng", dng, "dng"},
+		{"tiff", encodeTestImages(t)["tiff"], ""},
+		{"jpeg", encodeTestImages(t)["jpeg"], ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, "upload.passthrough_formats", nil)
+			info, err := DetectImage(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if tc.want != "" && !errors.Is(err, ErrInvalidImage) {
+				t.Fatalf("%s accepted without passthrough_formats: %+v, %v", tc.name, info, err)
+			}
+
+			useConfig(t, "upload.passthrough_formats", []string{"mp4", "mov", "heic", "cr3", "webm", "mkv", "avi", "cr2", "raf", "orf", "rw2", "dng"})
+			info, err = DetectImage(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want == "" {
+				if info.Passthrough {
+					t.Fatalf("image detected as passthrough format %s", info.Format)
+				}
+				return
+			}
+			if !info.Passthrough || info.Format != tc.want {
+				t.Fatalf("got %+v, want passthrough %s", info, tc.want)
+			}
+			if name := ImageFilename("clip.bin", info); filepath.Ext(name) == ".bin" {
+				t.Fatalf("ImageFilename = %s, want the %s extension", name, tc.want)
+			}
+		})
+	}
+}
+
+func TestDetectImageTruncated(t *testing.T) {
+	useAllowedFormats(t, "jpeg", "gif", "tiff")
+	for name, data := range encodeTestImages(t) {
+		if name == "png" {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			truncated := data[:len(data)-len(data)/4]
+			if _, err := DetectImage(bytes.NewReader(truncated), int64(len(truncated))); !errors.Is(err, ErrInvalidImage) {
+				t.Fatalf("got %v, want ErrInvalidImage", err)
+			}
+		})
+	}
+}
+
+func TestJPEGZeroPadding(t *testing.T) {
+	data := encodeTestImages(t)["jpeg"]
+	padded := append(append([]byte(nil), data...), make([]byte, 16)...)
+	if end, err := jpegEnd(bytes.NewReader(padded), int64(len(padded))); err != nil || end != int64(len(padded)) {
+		t.Fatalf("jpegEnd with zero padding = %d, %v, want %d", end, err, len(padded))
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/telegram-photo/model"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// DefaultSimilarDistance 判断为相似图片的默认最大汉明距离
+	DefaultSimilarDistance = 10
+	// MaxSimilarDistance 查询相似图片时允许的最大汉明距离
+	MaxSimilarDistance = 32
+	// phashQueueSize 等待计算感知哈希的文件数上限，队列已满时跳过，由补全任务处理
+	phashQueueSize = 100
+)
+
+// PerceptualHash 计算图片的差异哈希（dHash），返回16位十六进制字符串
+// 图片缩小为9x8的灰度图后比较每行相邻像素的亮度，对缩放、重新压缩和轻微调色不敏感
+// 两张图片哈希的汉明距离越小越相似
+func PerceptualHash(r io.ReadSeeker) (string, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", fmt.Errorf("不支持的图片格式: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return "", fmt.Errorf("图片尺寸过大: %dx%d", cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return "", fmt.Errorf("解码图片失败: %w", err)
+	}
+
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(small, small.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// perceptualHashLimited 占用图片处理名额计算感知哈希，解码整张图片的内存占用与缩放图片相同
+func perceptualHashLimited(ctx context.Context, r io.ReadSeeker) (string, error) {
+	release, err := AcquireTransformSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return PerceptualHash(r)
+}
+
+// phashJob 等待计算感知哈希的文件
+type phashJob struct {
+	fileID uint
+	upload *UploadFile
+}
+
+var phashQueue struct {
+	once sync.Once
+	jobs chan phashJob
+	wg   sync.WaitGroup
+}
+
+// schedulePerceptualHash 在后台计算文件的感知哈希并保存，上传不等待解码完成
+// 计算完成前upload保持打开，调用方可以照常关闭
+func schedulePerceptualHash(fileID uint, upload *UploadFile) {
+	phashQueue.once.Do(func() {
+		phashQueue.jobs = make(chan phashJob, phashQueueSize)
+		go runPerceptualHashes()
+	})
+
+	upload.hold()
+	phashQueue.wg.Add(1)
+	select {
+	case phashQueue.jobs <- phashJob{fileID: fileID, upload: upload}:
+	default:
+		phashQueue.wg.Done()
+		upload.release()
+		log.Printf("感知哈希队列已满，跳过 - 文件ID: %d", fileID)
+	}
+}
+
+// runPerceptualHashes 逐个计算队列中文件的感知哈希，无法解码的文件不填写
+func runPerceptualHashes() {
+	for job := range phashQueue.jobs {
+		phash, err := perceptualHashLimited(context.Background(), job.upload.Reader())
+		if err == nil {
+			err = model.UpdateFile(job.fileID, map[string]interface{}{"phash": phash})
+		}
+		if err != nil {
+			log.Printf("计算感知哈希失败 - 文件ID: %d, 错误: %v", job.fileID, err)
+		}
+		job.upload.release()
+		phashQueue.wg.Done()
+	}
+}
+
+// waitPerceptualHashes 等待已提交的感知哈希计算完成
+func waitPerceptualHashes() {
+	phashQueue.wg.Wait()
+}
+
+// HashDistance 两个感知哈希的汉明距离
+func HashDistance(a, b string) (int, error) {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("感知哈希格式错误: %q", a)
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("感知哈希格式错误: %q", b)
+	}
+	return bits.OnesCount64(x ^ y), nil
+}
+
+// similarMinBandBits 分段查找候选时每段的最小位数，段更短时分桶几乎不能减少比较次数
+const similarMinBandBits = 4
+
+// ClusterSimilar 将汉明距离不超过maxDistance的哈希归为一组，相似关系可以传递
+// 返回各组在hashes中的下标，只包含两个及以上成员的组，按成员数从多到少排列；格式错误的哈希忽略
+// 64位哈希分为maxDistance+1段，距离不超过maxDistance的两个哈希至少有一段完全相同，只比较有相同段的哈希
+func ClusterSimilar(hashes []string, maxDistance int) [][]int {
+	values := make([]uint64, len(hashes))
+	valid := make([]bool, len(hashes))
+	for i, h := range hashes {
+		v, err := strconv.ParseUint(h, 16, 64)
+		if err == nil {
+			values[i], valid[i] = v, true
+		}
+	}
+
+	// 并查集合并相似的哈希
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) {
+		if a, b := find(i), find(j); a != b {
+			parent[b] = a
+		}
+	}
+
+	// 相同的哈希直接合并，之后每个哈希值只比较一次
+	first := make(map[uint64]int)
+	var unique []int
+	for i, v := range values {
+		if !valid[i] {
+			continue
+		}
+		if j, ok := first[v]; ok {
+			union(j, i)
+			continue
+		}
+		first[v] = i
+		unique = append(unique, i)
+	}
+
+	// compare 两两比较一组哈希，已在同一组的跳过
+	compare := func(group []int) {
+		for x, i := range group {
+			for _, j := range group[x+1:] {
+				if find(i) != find(j) && bits.OnesCount64(values[i]^values[j]) <= maxDistance {
+					union(i, j)
+				}
+			}
+		}
+	}
+
+	bands := maxDistance + 1
+	if maxDistance < 0 || 64/bands < similarMinBandBits {
+		compare(unique)
+	} else {
+		for b := 0; b < bands; b++ {
+			lo, hi := b*64/bands, (b+1)*64/bands
+			mask := (uint64(1)<<(hi-lo) - 1) << lo
+			buckets := make(map[uint64][]int)
+			for _, i := range unique {
+				buckets[values[i]&mask] = append(buckets[values[i]&mask], i)
+			}
+			for _, group := range buckets {
+				compare(group)
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	var roots []int
+	for i := range values {
+		if !valid[i] {
+			continue
+		}
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+
+	clusters := make([][]int, 0)
+	for _, root := range roots {
+		if len(groups[root]) > 1 {
+			clusters = append(clusters, groups[root])
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i]) > len(clusters[j])
+	})
+	return clusters
+}
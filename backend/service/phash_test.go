@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// bruteForceClusters 两两比较所有哈希得到的分组，每组按下标排序
+func bruteForceClusters(hashes []string, maxDistance int) map[string]bool {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			x, _ := strconv.ParseUint(hashes[i], 16, 64)
+			y, _ := strconv.ParseUint(hashes[j], 16, 64)
+			if bits.OnesCount64(x^y) <= maxDistance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := map[int][]int{}
+	for i := range hashes {
+		groups[find(i)] = append(groups[find(i)], i)
+	}
+	result := map[string]bool{}
+	for _, group := range groups {
+		if len(group) > 1 {
+			result[fmt.Sprint(group)] = true
+		}
+	}
+	return result
+}
+
+// similarTestHashes 生成若干组相近的哈希，包含完全相同的哈希
+func similarTestHashes(rng *rand.Rand, groups, size int) []string {
+	var hashes []string
+	for g := 0; g < groups; g++ {
+		base := rng.Uint64()
+		for i := 0; i < size; i++ {
+			v := base
+			for flips := rng.Intn(12); flips > 0; flips-- {
+				v ^= 1 << rng.Intn(64)
+			}
+			hashes = append(hashes, fmt.Sprintf("%016x", v))
+		}
+	}
+	rng.Shuffle(len(hashes), func(i, j int) { hashes[i], hashes[j] = hashes[j], hashes[i] })
+	return hashes
+}
+
+func TestClusterSimilarMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hashes := similarTestHashes(rng, 40, 8)
+
+	for _, distance := range []int{0, 3, 7, DefaultSimilarDistance, 15, 20, MaxSimilarDistance} {
+		t.Run(strconv.Itoa(distance), func(t *testing.T) {
+			want := bruteForceClusters(hashes, distance)
+			clusters := ClusterSimilar(hashes, distance)
+			if len(clusters) != len(want) {
+				t.Fatalf("got %d clusters, want %d", len(clusters), len(want))
+			}
+			for i, cluster := range clusters {
+				if i > 0 && len(cluster) > len(clusters[i-1]) {
+					t.Fatal("clusters should be sorted by size")
+				}
+				sorted := append([]int(nil), cluster...)
+				sort.Ints(sorted)
+				if !want[fmt.Sprint(sorted)] {
+					t.Fatalf("unexpected cluster %v", sorted)
+				}
+			}
+		})
+	}
+}
+
+func TestClusterSimilarIgnoresInvalid(t *testing.T) {
+	hashes := []string{"00000000000000ff", "bad", "00000000000000fe", "", "ffffffffffffffff", "00000000000000ff"}
+	clusters := ClusterSimilar(hashes, 1)
+	if len(clusters) != 1 || fmt.Sprint(clusters[0]) != "[0 2 5]" {
+		t.Fatalf("got %v, want [[0 2 5]]", clusters)
+	}
+}
+
+func BenchmarkClusterSimilar(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	hashes := similarTestHashes(rng, 2000, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ClusterSimilar(hashes, DefaultSimilarDistance)
+	}
+}
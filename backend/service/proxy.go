@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// 出站请求的目标，可分别配置代理
+const (
+	ProxyTargetTelegram = "telegram"
+	ProxyTargetGitHub   = "github"
+)
+
+// proxyDirect 代理地址配置为该值时不使用代理
+const proxyDirect = "direct"
+
+// ProxyConfig 出站代理配置
+type ProxyConfig struct {
+	// URL 代理地址，支持http、https、socks5和socks5h，为空时使用HTTP_PROXY等环境变量
+	URL string
+	// NoProxy 不使用代理的主机，逗号分隔，格式同NO_PROXY环境变量；使用环境变量中的代理时与NO_PROXY合并
+	NoProxy string
+}
+
+// ProxyFunc 根据配置生成http.Transport使用的代理选择函数
+func (c ProxyConfig) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	switch c.URL {
+	case "":
+		// 使用环境变量中的代理，NoProxy中的主机与NO_PROXY环境变量一样不使用代理
+		env := httpproxy.FromEnvironment()
+		if c.NoProxy != "" {
+			env.NoProxy = strings.Trim(env.NoProxy+","+c.NoProxy, ",")
+		}
+		proxy := env.ProxyFunc()
+		return func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}, nil
+	case proxyDirect:
+		return nil, nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("代理地址格式错误: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("不支持的代理协议: %s", u.Scheme)
+	}
+
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  c.URL,
+		HTTPSProxy: c.URL,
+		NoProxy:    c.NoProxy,
+	}).ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// proxyConfigFor 读取某个目标的代理配置
+// proxy.<目标>.url和proxy.<目标>.no_proxy覆盖全局的proxy.url和proxy.no_proxy
+func proxyConfigFor(target string) ProxyConfig {
+	cfg := ProxyConfig{
+		URL:     strings.TrimSpace(viper.GetString("proxy.url")),
+		NoProxy: viper.GetString("proxy.no_proxy"),
+	}
+	if u := strings.TrimSpace(viper.GetString("proxy." + target + ".url")); u != "" {
+		cfg.URL = u
+	}
+	if noProxy := viper.GetString("proxy." + target + ".no_proxy"); noProxy != "" {
+		cfg.NoProxy = noProxy
+	}
+	return cfg
+}
+
+// NewProxyTransport 创建使用目标对应代理配置的Transport
+func NewProxyTransport(target string) (*http.Transport, error) {
+	proxy, err := proxyConfigFor(target).ProxyFunc()
+	if err != nil {
+		return nil, fmt.Errorf("%s代理配置错误: %w", target, err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return transport, nil
+}
+
+var (
+	outboundClientsMu sync.Mutex
+	outboundClients   = map[string]*http.Client{}
+)
+
+// OutboundHTTPClient 获取访问某个目标使用的HTTP客户端，按目标复用连接
+// 代理配置错误时返回错误，而不是绕过代理直接连接
+func OutboundHTTPClient(target string) (*http.Client, error) {
+	outboundClientsMu.Lock()
+	defer outboundClientsMu.Unlock()
+
+	if client, ok := outboundClients[target]; ok {
+		return client, nil
+	}
+
+	transport, err := NewProxyTransport(target)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: transport}
+	outboundClients[target] = client
+	return client, nil
+}
+
+// CheckProxyConfig 检查各目标的代理配置是否正确
+func CheckProxyConfig() error {
+	for _, target := range []string{ProxyTargetTelegram, ProxyTargetGitHub} {
+		if _, err := proxyConfigFor(target).ProxyFunc(); err != nil {
+			return fmt.Errorf("%s代理配置错误: %w", target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// resolveProxy 返回请求rawURL时使用的代理地址，不使用代理时返回空字符串
+func resolveProxy(t *testing.T, cfg ProxyConfig, rawURL string) string {
+	t.Helper()
+	proxy, err := cfg.ProxyFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy == nil {
+		return ""
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := proxy(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+func TestProxyFuncNoProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://env-proxy:3128")
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("NO_PROXY", "env-direct.example")
+
+	for _, tc := range []struct {
+		name string
+		cfg  ProxyConfig
+		url  string
+		want string
+	}{
+		{"env proxy", ProxyConfig{}, "https://api.telegram.org/bot", "http://env-proxy:3128"},
+		{"env no_proxy", ProxyConfig{}, "https://env-direct.example/", ""},
+		{"configured no_proxy with env proxy", ProxyConfig{NoProxy: ".internal"}, "https://bot-api.internal/bot", ""},
+		{"env no_proxy kept when configured", ProxyConfig{NoProxy: ".internal"}, "https://env-direct.example/", ""},
+		{"env proxy outside no_proxy", ProxyConfig{NoProxy: ".internal"}, "https://api.telegram.org/bot", "http://env-proxy:3128"},
+		{"configured proxy", ProxyConfig{URL: "socks5://127.0.0.1:1080"}, "https://api.telegram.org/bot", "socks5://127.0.0.1:1080"},
+		{"configured proxy no_proxy", ProxyConfig{URL: "socks5://127.0.0.1:1080", NoProxy: "api.telegram.org"}, "https://api.telegram.org/bot", ""},
+		{"direct", ProxyConfig{URL: proxyDirect}, "https://api.telegram.org/bot", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := resolveProxy(t, tc.cfg, tc.url); got != tc.want {
+				t.Fatalf("proxy = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProxyConfigForTarget(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("NO_PROXY", "")
+	t.Cleanup(viper.Reset)
+
+	// 全局代理为空时，目标的no_proxy同样作用于环境变量中的代理
+	viper.Set("proxy.url", "")
+	viper.Set("proxy.telegram.no_proxy", "bot-api.lan")
+	if got := resolveProxy(t, proxyConfigFor(ProxyTargetTelegram), "https://bot-api.lan/bot"); got != "" {
+		t.Fatalf("telegram proxy for no_proxy host = %q, want direct", got)
+	}
+	if got := resolveProxy(t, proxyConfigFor(ProxyTargetGitHub), "https://bot-api.lan/"); got != "http://env-proxy:3128" {
+		t.Fatalf("github proxy = %q, want env proxy", got)
+	}
+
+	// 目标的代理覆盖全局配置，并使用全局的no_proxy
+	viper.Set("proxy.no_proxy", "github.com")
+	viper.Set("proxy.github.url", "socks5h://127.0.0.1:1080")
+	if got := resolveProxy(t, proxyConfigFor(ProxyTargetGitHub), "https://github.com/login"); got != "" {
+		t.Fatalf("github proxy for no_proxy host = %q, want direct", got)
+	}
+	if got := resolveProxy(t, proxyConfigFor(ProxyTargetGitHub), "https://avatars.githubusercontent.com/u/1"); got != "socks5h://127.0.0.1:1080" {
+		t.Fatalf("github proxy = %q, want override", got)
+	}
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrForbiddenAddress 目标地址为内网或保留地址
+var ErrForbiddenAddress = errors.New("不允许访问内网或保留地址")
+
+// maxRemoteRedirects 下载远程文件时最多跟随的重定向次数
+const maxRemoteRedirects = 5
+
+// RemoteFile 下载的远程文件，调用方负责关闭File
+type RemoteFile struct {
+	File        *UploadFile
+	Filename    string
+	ContentType string
+}
+
+// blockedNetworks 禁止访问的地址段，防止通过服务器访问内网服务
+var blockedNetworks = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",       // 本网络
+		"10.0.0.0/8",      // 私有地址
+		"100.64.0.0/10",   // 运营商级NAT
+		"127.0.0.0/8",     // 回环地址
+		"169.254.0.0/16",  // 链路本地地址（含云服务器元数据接口）
+		"172.16.0.0/12",   // 私有地址
+		"192.0.0.0/24",    // IETF协议分配
+		"192.0.2.0/24",    // 文档示例
+		"192.88.99.0/24",  // 6to4中继任播
+		"192.168.0.0/16",  // 私有地址
+		"198.18.0.0/15",   // 基准测试
+		"198.51.100.0/24", // 文档示例
+		"203.0.113.0/24",  // 文档示例
+		"224.0.0.0/4",     // 组播
+		"240.0.0.0/4",     // 保留地址
+		"::/128",          // 未指定地址
+		"::1/128",         // 回环地址
+		"::/96",           // IPv4兼容地址，内嵌IPv4地址
+		"64:ff9b::/96",    // NAT64，可映射到内网IPv4地址
+		"64:ff9b:1::/48",  // 本地NAT64
+		"100::/64",        // 丢弃前缀
+		"2001::/32",       // Teredo，内嵌IPv4地址
+		"2001:db8::/32",   // 文档示例
+		"2002::/16",       // 6to4，内嵌IPv4地址
+		"fc00::/7",        // 唯一本地地址
+		"fe80::/10",       // 链路本地地址
+		"ff00::/8",        // 组播
+	}
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPublicIP 是否为允许访问的公网地址
+func isPublicIP(ip net.IP) bool {
+	// IPv4映射的IPv6地址按IPv4地址判断
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// remoteClient 下载远程文件使用的HTTP客户端
+// 在建立连接时检查解析后的IP地址，避免DNS重绑定绕过检查，重定向后的地址同样会被检查
+func remoteClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if viper.GetBool("upload.url_allow_private") {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// 不使用代理，否则连接的是代理服务器，无法检查目标地址
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		// 每次下载使用新的客户端，不保留空闲连接
+		DisableKeepAlives: true,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRemoteRedirects {
+				return fmt.Errorf("重定向次数过多")
+			}
+			return checkRemoteURL(req.URL)
+		},
+	}
+}
+
+// checkRemoteURL 检查地址的协议和主机
+func checkRemoteURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("只支持http和https地址")
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("地址缺少主机名")
+	}
+	if u.User != nil {
+		return fmt.Errorf("地址不能包含用户名和密码")
+	}
+	return nil
+}
+
+// FetchRemoteImage 下载远程图片
+// 限制大小和下载时间，禁止访问内网地址，并根据文件内容判断是否为图片
+func FetchRemoteImage(ctx context.Context, rawURL string, maxSize int64) (*RemoteFile, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("地址格式错误: %w", err)
+	}
+	if err := checkRemoteURL(u); err != nil {
+		return nil, err
+	}
+
+	timeout := viper.GetDuration("upload.url_timeout")
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "telegram-photo/1.0")
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := remoteClient(timeout).Do(req)
+	if err != nil {
+		if errors.Is(err, ErrForbiddenAddress) {
+			return nil, ErrForbiddenAddress
+		}
+		return nil, fmt.Errorf("下载失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载失败: HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("图片大小不能超过%dMB", maxSize/1024/1024)
+	}
+
+	// 以文件内容判断类型，无法识别时参考响应头
+	body := bufio.NewReaderSize(resp.Body, 512)
+	head, err := body.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("下载失败: %w", err)
+	}
+	contentType := http.DetectContentType(head)
+	if !strings.HasPrefix(contentType, "image/") {
+		declared, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if contentType != "application/octet-stream" || !strings.HasPrefix(declared, "image/") {
+			return nil, fmt.Errorf("不是图片文件: %s", contentType)
+		}
+		contentType = declared
+	}
+
+	file, err := SpoolUpload(body, maxSize)
+	if errors.Is(err, ErrFileTooLarge) {
+		return nil, fmt.Errorf("图片大小不能超过%dMB", maxSize/1024/1024)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("下载失败: %w", err)
+	}
+
+	return &RemoteFile{
+		File:        file,
+		Filename:    remoteFilename(resp, contentType),
+		ContentType: contentType,
+	}, nil
+}
+
+// remoteFilename 根据Content-Disposition或地址路径确定文件名，缺少扩展名时按类型补全
+func remoteFilename(resp *http.Response, contentType string) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = path.Base(params["filename"])
+	}
+	if name == "" || name == "." || name == "/" {
+		name = path.Base(resp.Request.URL.Path)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "image"
+	}
+
+	if path.Ext(name) == "" {
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return name
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"10.0.0.1", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:8.8.8.8", true},
+		{"fd00::1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b:1::a00:1", false},
+		// 6to4和Teredo地址内嵌IPv4地址，可能经中继访问到内网
+		{"2002:7f00:1::1", false},
+		{"2002:a9fe:a9fe::1", false},
+		{"2001:0:4136:e378:8000:63bf:80ff:fffe", false},
+		{"2001:4860:4860::8888", true},
+		{"192.88.99.1", false},
+		{"::7f00:1", false},
+		{"::a9fe:a9fe", false},
+	} {
+		ip := net.ParseIP(tc.ip)
+		if ip == nil {
+			t.Fatalf("invalid test address %s", tc.ip)
+		}
+		if got := isPublicIP(ip); got != tc.public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tc.ip, got, tc.public)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// 存储驱动名称
+const (
+	StorageDriverTelegram = "telegram"
+	StorageDriverLocal    = "local"
+	StorageDriverS3       = "s3"
+)
+
+// 上传模式
+const (
+	// UploadModePhoto 以图片消息发送，Telegram会压缩并缩放图片
+	UploadModePhoto = "photo"
+	// UploadModeDocument 以文档消息发送，保留原始文件内容
+	UploadModeDocument = "document"
+)
+
+var (
+	// ErrObjectNotFound 存储后端中不存在该文件
+	ErrObjectNotFound = errors.New("文件不存在")
+	// ErrNotSupported 存储后端不支持该操作
+	ErrNotSupported = errors.New("存储后端不支持该操作")
+)
+
+// Storage 文件存储后端
+type Storage interface {
+	// Name 返回驱动名称
+	Name() string
+	// Put 保存文件内容，返回文件在该后端中的标识
+	Put(ctx context.Context, r io.Reader, opts PutOptions) (*PutResult, error)
+	// Get 读取文件内容，调用方负责关闭返回的Body
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete 删除文件
+	Delete(ctx context.Context, key string) error
+	// Stat 获取文件信息
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+}
+
+// RangeGetter 支持按范围读取文件的存储后端
+type RangeGetter interface {
+	// GetRange 读取文件从offset开始的length字节，length小于0时读取到末尾，调用方负责关闭
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// PutOptions 保存文件时的参数
+type PutOptions struct {
+	Filename    string
+	Size        int64
+	ContentType string
+	// Mode 上传模式，仅Telegram驱动区分，为空时使用配置的默认模式
+	Mode string
+}
+
+// PutResult 保存文件的结果
+type PutResult struct {
+	Key  string
+	Size int64
+	// Mode 实际使用的上传模式
+	Mode string
+	// Chunks 文件被拆分存储时的各分块，按顺序排列，Key为第一个分块的标识
+	Chunks []Chunk
+	// Variants 存储后端生成的各尺寸版本，按尺寸从小到大排列
+	Variants []Variant
+	// Bot 上传文件的Telegram机器人ID，file_id只对该机器人有效，仅Telegram驱动返回
+	Bot string
+}
+
+// Variant 图片的某一尺寸版本
+type Variant struct {
+	Key    string
+	Width  int
+	Height int
+	Size   int64
+}
+
+// Chunk 文件分块
+type Chunk struct {
+	Key  string
+	Size int64
+}
+
+// ObjectInfo 文件信息
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Object 文件内容
+type Object struct {
+	ObjectInfo
+	Body io.ReadCloser
+}
+
+var (
+	storages       = map[string]Storage{}
+	defaultStorage Storage
+)
+
+// InitStorage 根据配置初始化存储后端
+func InitStorage() error {
+	storages = map[string]Storage{
+		StorageDriverTelegram: NewTelegramStorage(),
+		StorageDriverLocal:    NewLocalStorage(viper.GetString("storage.local.path")),
+	}
+
+	driver := viper.GetString("storage.driver")
+	if driver == "" {
+		driver = StorageDriverTelegram
+	}
+
+	// 配置了bucket时注册S3驱动，已存入S3的文件在切换默认驱动后仍可访问
+	if viper.GetString("storage.s3.bucket") != "" || driver == StorageDriverS3 {
+		s3, err := NewS3Storage(S3Config{
+			Endpoint:  viper.GetString("storage.s3.endpoint"),
+			Region:    viper.GetString("storage.s3.region"),
+			Bucket:    viper.GetString("storage.s3.bucket"),
+			AccessKey: viper.GetString("storage.s3.access_key"),
+			SecretKey: viper.GetString("storage.s3.secret_key"),
+			Prefix:    viper.GetString("storage.s3.prefix"),
+			PathStyle: viper.GetBool("storage.s3.path_style"),
+		})
+		if err != nil {
+			return err
+		}
+		storages[StorageDriverS3] = s3
+	}
+
+	st, ok := storages[driver]
+	if !ok {
+		return fmt.Errorf("未知的存储驱动: %s", driver)
+	}
+	defaultStorage = st
+
+	return nil
+}
+
+// DefaultStorage 获取当前配置的存储后端
+func DefaultStorage() Storage {
+	if defaultStorage == nil {
+		return NewTelegramStorage()
+	}
+	return defaultStorage
+}
+
+// GetStorage 根据驱动名称获取存储后端，名称为空时视为Telegram（早期的文件记录）
+func GetStorage(name string) (Storage, error) {
+	if name == "" {
+		name = StorageDriverTelegram
+	}
+	st, ok := storages[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的存储驱动: %s", name)
+	}
+	return st, nil
+}
+
+// GetFileStorage 获取读取某个文件的存储后端，Telegram文件需使用上传它的机器人读取
+func GetFileStorage(name, bot string) (Storage, error) {
+	st, err := GetStorage(name)
+	if err != nil {
+		return nil, err
+	}
+	if ts, ok := st.(*TelegramStorage); ok {
+		return ts.ForBot(bot), nil
+	}
+	return st, nil
+}
+
+// GetRange 读取文件从offset开始的length字节，length小于0时读取到末尾
+// 存储后端不支持按范围读取时下载整个文件并跳过offset之前的内容
+func GetRange(ctx context.Context, st Storage, key string, offset, length int64) (io.ReadCloser, error) {
+	if rg, ok := st.(RangeGetter); ok && (offset > 0 || length >= 0) {
+		return rg.GetRange(ctx, key, offset, length)
+	}
+	object, err := st.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return limitBody(object.Body, offset, length)
+}
+
+// limitBody 跳过body开头offset字节并最多读取length字节，length小于0时不限制
+func limitBody(body io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+			body.Close()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, length), body}, nil
+}
+
+// rangeHeader 生成读取从offset开始的length字节的Range请求头
+func rangeHeader(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// rangeBody 处理Range请求的响应，服务器忽略Range返回完整内容时跳过offset之前的内容
+func rangeBody(resp *http.Response, offset, length int64) (io.ReadCloser, error) {
+	if resp.StatusCode == http.StatusPartialContent {
+		return limitBody(resp.Body, 0, length)
+	}
+	return limitBody(resp.Body, offset, length)
+}
+
+// NewChunkReader 按顺序拼接各分块的内容，读取到某一分块时才向存储后端请求该分块
+func NewChunkReader(ctx context.Context, st Storage, keys []string) io.ReadCloser {
+	parts := make([]chunkPart, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, chunkPart{key: key, length: -1})
+	}
+	return &chunkReader{ctx: ctx, st: st, parts: parts}
+}
+
+// NewChunkRangeReader 读取分块文件从offset开始的length字节，length小于0时读取到末尾
+// 只请求与该范围重叠的分块，首尾分块按范围读取
+func NewChunkRangeReader(ctx context.Context, st Storage, chunks []Chunk, offset, length int64) io.ReadCloser {
+	var parts []chunkPart
+	var start int64
+	for _, chunk := range chunks {
+		end := start + chunk.Size
+		if end > offset && (length < 0 || start < offset+length) {
+			part := chunkPart{key: chunk.Key, offset: max(offset-start, 0), length: -1}
+			if length >= 0 && offset+length < end {
+				part.length = offset + length - start - part.offset
+			}
+			parts = append(parts, part)
+		}
+		start = end
+	}
+	return &chunkReader{ctx: ctx, st: st, parts: parts}
+}
+
+type chunkReader struct {
+	ctx   context.Context
+	st    Storage
+	parts []chunkPart
+	cur   io.ReadCloser
+}
+
+// chunkPart 要读取的分块范围，length小于0时读取到分块末尾
+type chunkPart struct {
+	key            string
+	offset, length int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			part := r.parts[0]
+			body, err := GetRange(r.ctx, r.st, part.key, part.offset, part.length)
+			if err != nil {
+				return 0, fmt.Errorf("读取分块失败: %w", err)
+			}
+			r.parts = r.parts[1:]
+			r.cur = body
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// ValidUploadMode 检查上传模式是否合法
+func ValidUploadMode(mode string) bool {
+	return mode == UploadModePhoto || mode == UploadModeDocument
+}
+
+// DefaultUploadMode 配置的默认上传模式
+func DefaultUploadMode() string {
+	mode := viper.GetString("telegram.upload_mode")
+	if !ValidUploadMode(mode) {
+		return UploadModePhoto
+	}
+	return mode
+}
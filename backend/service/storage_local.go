@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage 本地文件系统存储
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage 创建本地文件系统存储后端
+func NewLocalStorage(root string) *LocalStorage {
+	if root == "" {
+		root = "./uploads"
+	}
+	return &LocalStorage{root: root}
+}
+
+// Name 返回驱动名称
+func (s *LocalStorage) Name() string {
+	return StorageDriverLocal
+}
+
+// Put 将文件写入本地目录，文件标识为随机生成的文件名
+func (s *LocalStorage) Put(ctx context.Context, r io.Reader, opts PutOptions) (*PutResult, error) {
+	key, err := newLocalKey(opts.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建存储目录失败: %w", err)
+	}
+
+	// 先写入临时文件，完成后再重命名，避免读取到不完整的文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("写入文件失败: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("保存文件失败: %w", err)
+	}
+
+	return &PutResult{Key: key, Size: size, Mode: UploadModeDocument}, nil
+}
+
+// Get 打开本地文件
+func (s *LocalStorage) Get(ctx context.Context, key string) (*Object, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{ObjectInfo: *info, Body: f}, nil
+}
+
+// GetRange 打开本地文件并定位到offset
+func (s *LocalStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	object, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := object.Body.(*os.File).Seek(offset, io.SeekStart); err != nil {
+		object.Body.Close()
+		return nil, err
+	}
+	return limitBody(object.Body, 0, length)
+}
+
+// Delete 删除本地文件
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if !validLocalKey(key) {
+		return ErrObjectNotFound
+	}
+	if err := os.Remove(s.path(key)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Stat 获取本地文件信息
+func (s *LocalStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if !validLocalKey(key) {
+		return nil, ErrObjectNotFound
+	}
+
+	fi, err := os.Stat(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &ObjectInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: contentType,
+		ModTime:     fi.ModTime(),
+	}, nil
+}
+
+// path 按文件名前两位分目录，避免单个目录下文件过多
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.root, key[:2], key)
+}
+
+// newLocalKey 生成随机文件名，保留原始扩展名
+func newLocalKey(filename string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !validLocalKey("xx" + ext) {
+		ext = ""
+	}
+
+	return hex.EncodeToString(buf) + ext, nil
+}
+
+// validLocalKey 只允许字母、数字和点，防止路径穿越
+func validLocalKey(key string) bool {
+	if len(key) < 2 || strings.HasPrefix(key, ".") {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.') {
+			return false
+		}
+	}
+	return !strings.Contains(key, "..")
+}
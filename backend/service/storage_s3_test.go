@@ -0,0 +1,365 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testS3AccessKey = "minioadmin"
+	testS3SecretKey = "minio-secret"
+	testS3Region    = "us-east-1"
+	testS3Bucket    = "photos"
+)
+
+// fakeS3 MinIO风格的S3替身，校验SigV4签名并在内存中保存对象
+type fakeS3 struct {
+	pathStyle bool
+
+	mu       sync.Mutex
+	objects  map[string]fakeS3Object
+	requests []string
+}
+
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(pathStyle bool) *fakeS3 {
+	return &fakeS3{pathStyle: pathStyle, objects: map[string]fakeS3Object{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.Host+r.URL.EscapedPath())
+	f.mu.Unlock()
+
+	if err := verifySigV4(r, testS3SecretKey); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>"+err.Error()+"</Message></Error>")
+		return
+	}
+
+	// 按寻址方式解析对象键
+	var key string
+	if f.pathStyle {
+		if r.Host != "s3.test" || !strings.HasPrefix(r.URL.Path, "/"+testS3Bucket+"/") {
+			http.Error(w, "bad path-style request", http.StatusBadRequest)
+			return
+		}
+		key = strings.TrimPrefix(r.URL.Path, "/"+testS3Bucket+"/")
+	} else {
+		if r.Host != testS3Bucket+".s3.test" {
+			http.Error(w, "bad virtual-host request", http.StatusBadRequest)
+			return
+		}
+		key = strings.TrimPrefix(r.URL.Path, "/")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if int64(len(data)) != r.ContentLength {
+			http.Error(w, "content length mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = fakeS3Object{data: data, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		// ServeContent处理HEAD和Range请求
+		http.ServeContent(w, r, "", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), bytes.NewReader(obj.data))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verifySigV4 按AWS规范独立重建规范请求并校验签名
+func verifySigV4(r *http.Request, secret string) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return errors.New("missing AWS4-HMAC-SHA256 authorization")
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != testS3AccessKey || credential[2] != testS3Region ||
+		credential[3] != "s3" || credential[4] != "aws4_request" {
+		return errors.New("bad credential scope: " + fields["Credential"])
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(signedAt).Abs() > 15*time.Minute {
+		return errors.New("bad x-amz-date: " + amzDate)
+	}
+	if credential[1] != amzDate[:8] {
+		return errors.New("credential date does not match x-amz-date")
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) {
+		return errors.New("signed headers not sorted")
+	}
+	required := map[string]bool{"host": false, "x-amz-date": false, "x-amz-content-sha256": false}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		if _, ok := required[name]; ok {
+			required[name] = true
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	for name, ok := range required {
+		if !ok {
+			return errors.New("header not signed: " + name)
+		}
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		headers.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		strings.Join(credential[1:], "/"),
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+secret), credential[1])
+	key = mac(key, credential[2])
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	if want := hex.EncodeToString(mac(key, stringToSign)); want != fields["Signature"] {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// newTestS3Storage 创建连接到替身的S3存储，所有主机名都解析到替身服务
+func newTestS3Storage(t *testing.T, fake *fakeS3, cfg S3Config) *S3Storage {
+	t.Helper()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg.Endpoint = "http://s3.test"
+	if cfg.Bucket == "" {
+		cfg.Bucket = testS3Bucket
+	}
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = testS3AccessKey
+	}
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = testS3SecretKey
+	}
+
+	st, err := NewS3Storage(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+	addr := srv.Listener.Addr().String()
+	st.client = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	return st
+}
+
+func TestS3StorageRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		pathStyle bool
+		prefix    string
+		wantHost  string
+		wantPath  string
+	}{
+		{name: "path-style", pathStyle: true, wantHost: "s3.test", wantPath: "/" + testS3Bucket + "/"},
+		{name: "virtual-host", pathStyle: false, wantHost: testS3Bucket + ".s3.test", wantPath: "/"},
+		{name: "path-style with prefix", pathStyle: true, prefix: "/images/", wantHost: "s3.test", wantPath: "/" + testS3Bucket + "/images/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeS3(tc.pathStyle)
+			st := newTestS3Storage(t, fake, S3Config{PathStyle: tc.pathStyle, Prefix: tc.prefix})
+			ctx := context.Background()
+			content := "hello s3"
+
+			result, err := st.Put(ctx, strings.NewReader(content), PutOptions{
+				Filename:    "cat.png",
+				Size:        int64(len(content)),
+				ContentType: "image/png",
+			})
+			if err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if !strings.HasSuffix(result.Key, ".png") || result.Size != int64(len(content)) {
+				t.Fatalf("unexpected put result: %+v", result)
+			}
+
+			info, err := st.Stat(ctx, result.Key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Size != int64(len(content)) || info.ContentType != "image/png" || info.ModTime.IsZero() {
+				t.Fatalf("unexpected stat: %+v", info)
+			}
+
+			obj, err := st.Get(ctx, result.Key)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			data, _ := io.ReadAll(obj.Body)
+			obj.Body.Close()
+			if string(data) != content {
+				t.Fatalf("Get returned %q, want %q", data, content)
+			}
+
+			for _, r := range []struct{ offset, length int64 }{{2, 3}, {4, -1}, {0, 1}} {
+				body, err := GetRange(ctx, st, result.Key, r.offset, r.length)
+				if err != nil {
+					t.Fatalf("GetRange(%d, %d): %v", r.offset, r.length, err)
+				}
+				data, _ := io.ReadAll(body)
+				body.Close()
+				want := content[r.offset:]
+				if r.length >= 0 {
+					want = want[:r.length]
+				}
+				if string(data) != want {
+					t.Fatalf("GetRange(%d, %d) returned %q, want %q", r.offset, r.length, data, want)
+				}
+			}
+
+			if err := st.Delete(ctx, result.Key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := st.Get(ctx, result.Key); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("Get after delete: got %v, want ErrObjectNotFound", err)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			for _, req := range fake.requests {
+				want := tc.wantHost + tc.wantPath
+				if !strings.Contains(req, " "+want) {
+					t.Errorf("request %q not addressed as %s", req, want)
+				}
+			}
+		})
+	}
+}
+
+func TestS3StorageUnknownSize(t *testing.T) {
+	fake := newFakeS3(true)
+	st := newTestS3Storage(t, fake, S3Config{PathStyle: true})
+
+	// 大小未知时先读入内存，请求仍带有正确的Content-Length
+	result, err := st.Put(context.Background(), io.MultiReader(strings.NewReader("abc"), strings.NewReader("def")), PutOptions{Filename: "a.jpg"})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if result.Size != 6 {
+		t.Fatalf("size = %d, want 6", result.Size)
+	}
+}
+
+func TestS3StorageBadSignature(t *testing.T) {
+	fake := newFakeS3(true)
+	st := newTestS3Storage(t, fake, S3Config{PathStyle: true, SecretKey: "wrong-secret"})
+
+	_, err := st.Put(context.Background(), strings.NewReader("x"), PutOptions{Filename: "a.jpg", Size: 1})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 403") || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with wrong secret: got %v, want 403 SignatureDoesNotMatch", err)
+	}
+}
+
+func TestS3StorageRejectsInvalidKey(t *testing.T) {
+	fake := newFakeS3(true)
+	st := newTestS3Storage(t, fake, S3Config{PathStyle: true})
+
+	if _, err := st.Get(context.Background(), "../secret"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("Get with invalid key: got %v, want ErrObjectNotFound", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Fatalf("invalid key should not reach the server, got %v", fake.requests)
+	}
+}
+
+func TestS3SignCanonicalRequest(t *testing.T) {
+	st, err := NewS3Storage(S3Config{
+		Endpoint:  "minio.local:9000",
+		Bucket:    testS3Bucket,
+		AccessKey: testS3AccessKey,
+		SecretKey: testS3SecretKey,
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := st.newRequest(context.Background(), http.MethodPut, "abc.jpg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.URL.String(); got != "https://minio.local:9000/photos/abc.jpg" {
+		t.Fatalf("url = %s", got)
+	}
+	req.Header.Set("Content-Type", "image/jpeg")
+	st.sign(req, time.Now().UTC())
+
+	// 服务端看到的Host与签名时的URL主机一致
+	req.Host = req.URL.Host
+	if err := verifySigV4(req, testS3SecretKey); err != nil {
+		t.Fatalf("signature check: %v", err)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date,") {
+		t.Fatalf("unexpected signed headers: %s", req.Header.Get("Authorization"))
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// telegramPhotoMaxSize sendPhoto接口允许的最大文件大小
+const telegramPhotoMaxSize = 10 * 1024 * 1024
+
+// TelegramStorage 以Telegram消息作为文件存储
+// 上传时从机器人池中选择机器人，下载时使用上传文件的机器人
+type TelegramStorage struct {
+	bot string
+}
+
+// NewTelegramStorage 创建Telegram存储后端
+func NewTelegramStorage() *TelegramStorage {
+	return &TelegramStorage{}
+}
+
+// ForBot 返回使用指定机器人读取文件的存储后端，botID为空时使用主机器人
+func (s *TelegramStorage) ForBot(botID string) *TelegramStorage {
+	return &TelegramStorage{bot: botID}
+}
+
+// Name 返回驱动名称
+func (s *TelegramStorage) Name() string {
+	return StorageDriverTelegram
+}
+
+// Put 上传文件到Telegram，文件标识为Telegram的file_id
+// 超过分块大小的文件拆分为多个文档分别发送，以绕开getFile的20MB下载限制
+// 同一文件的所有分块和尺寸版本由同一个机器人上传
+func (s *TelegramStorage) Put(ctx context.Context, r io.Reader, opts PutOptions) (*PutResult, error) {
+	pool, err := telegramBotPool()
+	if err != nil {
+		return nil, err
+	}
+
+	bot := pool.Acquire()
+	result, err := s.put(ctx, bot, r, opts)
+	pool.Release(bot, err)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Bot = bot.ID
+	return result, nil
+}
+
+// put 通过指定的机器人上传文件
+func (s *TelegramStorage) put(ctx context.Context, bot *TelegramBot, r io.Reader, opts PutOptions) (*PutResult, error) {
+	chunkSize := telegramChunkSize()
+	if opts.Size > chunkSize {
+		return s.putChunks(ctx, bot, r, opts, chunkSize)
+	}
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = DefaultUploadMode()
+	}
+	// sendPhoto最大只接受10MB，超过时改为文档发送
+	if mode == UploadModePhoto && opts.Size > telegramPhotoMaxSize {
+		mode = UploadModeDocument
+	}
+
+	if mode == UploadModeDocument {
+		fileID, err := UploadDocumentToTelegram(ctx, bot, r, opts.Filename)
+		if err != nil {
+			return nil, err
+		}
+		return &PutResult{Key: fileID, Size: opts.Size, Mode: mode}, nil
+	}
+
+	message, err := bot.client.SendPhoto(ctx, r, opts.Filename)
+	if err != nil {
+		return nil, err
+	}
+	fileID, err := messageFileID(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// Telegram为图片生成多个尺寸，全部保留用于缩略图
+	variants := make([]Variant, 0, len(message.Photo))
+	for _, photo := range message.Photo {
+		variants = append(variants, Variant{
+			Key:    photo.FileID,
+			Width:  photo.Width,
+			Height: photo.Height,
+			Size:   int64(photo.FileSize),
+		})
+	}
+
+	return &PutResult{Key: fileID, Size: opts.Size, Mode: mode, Variants: variants}, nil
+}
+
+// putChunks 将文件按分块大小拆分后依次以文档形式上传
+func (s *TelegramStorage) putChunks(ctx context.Context, bot *TelegramBot, r io.Reader, opts PutOptions, chunkSize int64) (*PutResult, error) {
+	base := filepath.Base(opts.Filename)
+	var chunks []Chunk
+
+	for remaining, index := opts.Size, 1; remaining > 0; index++ {
+		size := chunkSize
+		if remaining < size {
+			size = remaining
+		}
+
+		// 支持ReaderAt时每个分块使用独立的SectionReader，失败重试时可以重新读取该分块
+		var chunk io.Reader
+		var counter *countingReader
+		if ra, ok := r.(io.ReaderAt); ok {
+			chunk = io.NewSectionReader(ra, opts.Size-remaining, size)
+		} else {
+			counter = &countingReader{r: io.LimitReader(r, size)}
+			chunk = counter
+		}
+
+		fileID, err := UploadDocumentToTelegram(ctx, bot, chunk, fmt.Sprintf("%s.part%03d", base, index))
+		if err != nil {
+			return nil, fmt.Errorf("上传第%d个分块失败: %w", index, err)
+		}
+		if counter != nil && counter.n != size {
+			return nil, fmt.Errorf("第%d个分块大小不符: 期望%d字节，实际%d字节", index, size, counter.n)
+		}
+
+		chunks = append(chunks, Chunk{Key: fileID, Size: size})
+		remaining -= size
+	}
+
+	return &PutResult{Key: chunks[0].Key, Size: opts.Size, Mode: UploadModeDocument, Chunks: chunks}, nil
+}
+
+// Get 从Telegram下载文件
+func (s *TelegramStorage) Get(ctx context.Context, key string) (*Object, error) {
+	bot, err := GetTelegramBot(s.bot)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := openTelegramFile(ctx, bot, key)
+	// 缓存的文件路径已失效时重新调用getFile
+	if errors.Is(err, errFilePathExpired) && InvalidateTelegramFilePath(key) {
+		object, err = openTelegramFile(ctx, bot, key)
+	}
+	if errors.Is(err, errFilePathExpired) {
+		return nil, ErrObjectNotFound
+	}
+	return object, err
+}
+
+// GetRange 从Telegram下载文件的一部分
+func (s *TelegramStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	bot, err := GetTelegramBot(s.bot)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := openTelegramFileRange(ctx, bot, key, offset, length)
+	// 缓存的文件路径已失效时重新调用getFile
+	if errors.Is(err, errFilePathExpired) && InvalidateTelegramFilePath(key) {
+		object, err = openTelegramFileRange(ctx, bot, key, offset, length)
+	}
+	if errors.Is(err, errFilePathExpired) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
+
+// Delete Telegram无法按file_id删除文件
+func (s *TelegramStorage) Delete(ctx context.Context, key string) error {
+	return ErrNotSupported
+}
+
+// Stat 通过getFile获取文件大小
+func (s *TelegramStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	bot, err := GetTelegramBot(s.bot)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := getTelegramFile(ctx, bot, key)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: int64(file.FileSize)}, nil
+}
+
+// telegramChunkSize 单个分块的大小，需小于getFile的20MB下载限制
+// 本地模式的Bot API服务没有下载限制，上传限制为2000MB
+func telegramChunkSize() int64 {
+	size := viper.GetInt64("telegram.chunk_size")
+	if size <= 0 {
+		if TelegramLocalMode() {
+			return 2000 * 1024 * 1024
+		}
+		size = 19 * 1024 * 1024
+	}
+	return size
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDocumentServer 模拟Bot API的sendDocument，记录收到的各文件名和内容
+type fakeDocumentServer struct {
+	mu    sync.Mutex
+	names []string
+	data  [][]byte
+}
+
+func (f *fakeDocumentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/sendDocument") {
+		http.NotFound(w, r)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "document" {
+			continue
+		}
+		data, _ := io.ReadAll(part)
+
+		f.mu.Lock()
+		f.names = append(f.names, part.FileName())
+		f.data = append(f.data, data)
+		id := fmt.Sprintf("doc-%d", len(f.data))
+		f.mu.Unlock()
+
+		result, _ := json.Marshal(Message{Document: &Document{FileID: id, FileSize: len(data)}})
+		json.NewEncoder(w).Encode(TelegramResponse{Ok: true, Result: result})
+		return
+	}
+	http.Error(w, "missing document", http.StatusBadRequest)
+}
+
+func TestTelegramPutChunks(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		chunkSize int64
+		// sequential 输入不支持ReaderAt，各分块从同一个Reader依次读取
+		sequential bool
+		// wantChunks 各分块的大小，为空表示不拆分
+		wantChunks []int64
+	}{
+		{"smaller than a chunk", 3, 4, false, nil},
+		{"exactly one chunk", 4, 4, false, nil},
+		{"one byte over", 5, 4, false, []int64{4, 1}},
+		{"exact multiple", 8, 4, false, []int64{4, 4}},
+		{"short last chunk", 10, 4, false, []int64{4, 4, 2}},
+		{"sequential reader", 10, 4, true, []int64{4, 4, 2}},
+		{"sequential exact multiple", 12, 4, true, []int64{4, 4, 4}},
+		{"one byte chunks", 3, 1, true, []int64{1, 1, 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeDocumentServer{}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			bot := useTestTelegramBot(t, srv.URL)
+			useConfig(t, "telegram.chunk_size", tc.chunkSize)
+
+			content := make([]byte, tc.size)
+			for i := range content {
+				content[i] = byte('a' + i%26)
+			}
+			var r io.Reader = bytes.NewReader(content)
+			if tc.sequential {
+				r = struct{ io.Reader }{r}
+			}
+
+			result, err := NewTelegramStorage().put(context.Background(), bot, r, PutOptions{
+				Filename: "dir/photo.jpg",
+				Size:     int64(tc.size),
+				Mode:     UploadModeDocument,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Mode != UploadModeDocument || result.Size != int64(tc.size) {
+				t.Fatalf("result %+v", result)
+			}
+			if !bytes.Equal(bytes.Join(fake.data, nil), content) {
+				t.Fatalf("uploaded %q, want %q", bytes.Join(fake.data, nil), content)
+			}
+
+			if tc.wantChunks == nil {
+				if result.Chunks != nil || len(fake.names) != 1 || fake.names[0] != "photo.jpg" {
+					t.Fatalf("unchunked upload sent %v, chunks %v", fake.names, result.Chunks)
+				}
+				return
+			}
+
+			if len(result.Chunks) != len(tc.wantChunks) {
+				t.Fatalf("%d chunks, want %d", len(result.Chunks), len(tc.wantChunks))
+			}
+			for i, chunk := range result.Chunks {
+				wantName := fmt.Sprintf("photo.jpg.part%03d", i+1)
+				if chunk.Size != tc.wantChunks[i] || int64(len(fake.data[i])) != chunk.Size || fake.names[i] != wantName {
+					t.Fatalf("chunk %d: %+v sent as %q with %d bytes, want %d bytes as %q",
+						i, chunk, fake.names[i], len(fake.data[i]), tc.wantChunks[i], wantName)
+				}
+				if chunk.Key != fmt.Sprintf("doc-%d", i+1) {
+					t.Fatalf("chunk %d key %q", i, chunk.Key)
+				}
+			}
+			if result.Key != result.Chunks[0].Key {
+				t.Fatalf("result key %q, want first chunk %q", result.Key, result.Chunks[0].Key)
+			}
+		})
+	}
+}
+
+func TestTelegramPutChunksShortInput(t *testing.T) {
+	fake := &fakeDocumentServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	bot := useTestTelegramBot(t, srv.URL)
+	useConfig(t, "telegram.chunk_size", int64(4))
+
+	// 输入比声明的大小少一个字节，最后一个分块大小不符
+	r := struct{ io.Reader }{strings.NewReader("abcdefghi")}
+	_, err := NewTelegramStorage().put(context.Background(), bot, r, PutOptions{Filename: "photo.jpg", Size: 10})
+	if err == nil || !strings.Contains(err.Error(), "第3个分块大小不符") {
+		t.Fatalf("put error = %v, want size mismatch of the third chunk", err)
+	}
+}
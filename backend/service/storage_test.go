@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memStorage 保存在内存中的存储后端，记录各次读取的范围
+type memStorage struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests []string
+}
+
+func (s *memStorage) Name() string { return "memory" }
+
+func (s *memStorage) Put(ctx context.Context, r io.Reader, opts PutOptions) (*PutResult, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *memStorage) Get(ctx context.Context, key string) (*Object, error) {
+	body, err := s.GetRange(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{ObjectInfo: ObjectInfo{Key: key}, Body: body}, nil
+}
+
+func (s *memStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	s.requests = append(s.requests, fmt.Sprintf("%s@%d+%d", key, offset, length))
+
+	data = data[offset:]
+	if length >= 0 {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStorage) Delete(ctx context.Context, key string) error { return ErrNotSupported }
+
+func (s *memStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	return nil, ErrNotSupported
+}
+
+// fullGetStorage 不支持按范围读取的存储后端
+type fullGetStorage struct{ st *memStorage }
+
+func (s fullGetStorage) Name() string { return s.st.Name() }
+func (s fullGetStorage) Put(ctx context.Context, r io.Reader, opts PutOptions) (*PutResult, error) {
+	return s.st.Put(ctx, r, opts)
+}
+func (s fullGetStorage) Get(ctx context.Context, key string) (*Object, error) {
+	return s.st.Get(ctx, key)
+}
+func (s fullGetStorage) Delete(ctx context.Context, key string) error { return s.st.Delete(ctx, key) }
+func (s fullGetStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	return s.st.Stat(ctx, key)
+}
+
+// newChunkedStorage 将"abcdefghij"按4、4、2字节拆分为分块a、b、c
+func newChunkedStorage() (*memStorage, []Chunk) {
+	st := &memStorage{objects: map[string][]byte{
+		"a": []byte("abcd"),
+		"b": []byte("efgh"),
+		"c": []byte("ij"),
+	}}
+	return st, []Chunk{{Key: "a", Size: 4}, {Key: "b", Size: 4}, {Key: "c", Size: 2}}
+}
+
+func TestNewChunkReader(t *testing.T) {
+	st, _ := newChunkedStorage()
+	st.objects["empty"] = nil
+
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{"all chunks", []string{"a", "b", "c"}, "abcdefghij"},
+		{"single chunk", []string{"c"}, "ij"},
+		{"empty chunk in between", []string{"a", "empty", "c"}, "abcdij"},
+		{"no chunks", nil, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := NewChunkReader(context.Background(), st, tc.keys)
+			defer body.Close()
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.want {
+				t.Fatalf("read %q, want %q", data, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewChunkRangeReader(t *testing.T) {
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+		// wantRequests 向存储后端请求的分块范围
+		wantRequests string
+	}{
+		{"whole file", 0, -1, "abcdefghij", "a@0+-1,b@0+-1,c@0+-1"},
+		{"first chunk exactly", 0, 4, "abcd", "a@0+-1"},
+		{"second chunk exactly", 4, 4, "efgh", "b@0+-1"},
+		{"inside one chunk", 5, 2, "fg", "b@1+2"},
+		{"across a boundary", 3, 2, "de", "a@3+-1,b@0+1"},
+		{"across all chunks", 3, 6, "defghi", "a@3+-1,b@0+-1,c@0+1"},
+		{"from a boundary to the end", 8, -1, "ij", "c@0+-1"},
+		{"last byte", 9, 1, "j", "c@1+-1"},
+		{"length past the end", 6, 100, "ghij", "b@2+-1,c@0+-1"},
+		{"at the end", 10, -1, "", ""},
+		{"empty range", 4, 0, "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			st, chunks := newChunkedStorage()
+			body := NewChunkRangeReader(context.Background(), st, chunks, tc.offset, tc.length)
+			defer body.Close()
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.want {
+				t.Fatalf("read %q, want %q", data, tc.want)
+			}
+			if got := strings.Join(st.requests, ","); got != tc.wantRequests {
+				t.Fatalf("requested %s, want %s", got, tc.wantRequests)
+			}
+
+			// 不支持按范围读取的后端下载整个分块后截取
+			st.requests = nil
+			body = NewChunkRangeReader(context.Background(), fullGetStorage{st}, chunks, tc.offset, tc.length)
+			defer body.Close()
+			if data, err := io.ReadAll(body); err != nil || string(data) != tc.want {
+				t.Fatalf("full get read %q, %v, want %q", data, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestChunkReaderLazy(t *testing.T) {
+	st, chunks := newChunkedStorage()
+	body := NewChunkRangeReader(context.Background(), st, chunks, 0, -1)
+	defer body.Close()
+
+	// 读取第一个分块时不请求后续分块
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(st.requests, ","); got != "a@0+-1" {
+		t.Fatalf("requested %s after the first chunk", got)
+	}
+
+	// 分块丢失时返回错误
+	delete(st.objects, "b")
+	if _, err := io.ReadAll(body); err == nil || !strings.Contains(err.Error(), "读取分块失败") {
+		t.Fatalf("read error = %v, want missing chunk", err)
+	}
+}
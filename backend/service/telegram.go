@@ -1,28 +1,47 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
-	"net/http"
-	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
-const (
-	telegramAPIBaseURL = "https://api.telegram.org/bot%s"
-	telegramFileBaseURL = "https://api.telegram.org/file/bot%s/%s"
-)
+// defaultTelegramAPIURL 官方Bot API地址
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+// errFilePathExpired 按getFile返回的路径下载文件时返回4xx，路径可能已失效
+var errFilePathExpired = errors.New("Telegram文件路径已失效")
 
 // TelegramResponse Telegram API响应
 type TelegramResponse struct {
-	Ok          bool            `json:"ok"`
-	Result      json.RawMessage `json:"result"`
-	ErrorCode   int             `json:"error_code,omitempty"`
-	Description string          `json:"description,omitempty"`
+	Ok          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
+}
+
+// ResponseParameters 请求失败时Telegram返回的附加信息
+type ResponseParameters struct {
+	MigrateToChatID int64 `json:"migrate_to_chat_id,omitempty"`
+	RetryAfter      int   `json:"retry_after,omitempty"`
+}
+
+// TelegramAPIError Telegram接口返回的错误
+type TelegramAPIError struct {
+	Code        int
+	Description string
+	// RetryAfter 触发频率限制时需要等待的时长
+	RetryAfter time.Duration
+}
+
+func (e *TelegramAPIError) Error() string {
+	return fmt.Sprintf("Telegram API错误: %s", e.Description)
 }
 
 // PhotoSize Telegram照片尺寸
@@ -58,136 +77,82 @@ type File struct {
 	FilePath     string `json:"file_path,omitempty"`
 }
 
-// UploadImageToTelegram 上传图片到Telegram
-func UploadImageToTelegram(file io.Reader, filename string) (string, error) {
-	// 获取配置
-	botToken := viper.GetString("telegram.bot_token")
-	chatID := viper.GetString("telegram.chat_id")
-
-	if botToken == "" || chatID == "" {
-		return "", fmt.Errorf("Telegram配置不完整")
-	}
-
-	// 准备请求URL
-	apiURL := fmt.Sprintf(telegramAPIBaseURL+"/sendPhoto", botToken)
-
-	// 创建multipart请求
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	// 添加chat_id字段
-	if err := writer.WriteField("chat_id", chatID); err != nil {
-		return "", err
-	}
-
-	// 添加图片文件
-	part, err := writer.CreateFormFile("photo", filepath.Base(filename))
-	if err != nil {
-		return "", err
-	}
-
-	// 复制文件内容
-	if _, err := io.Copy(part, file); err != nil {
-		return "", err
-	}
-
-	// 完成multipart写入
-	if err := writer.Close(); err != nil {
-		return "", err
-	}
-
-	// 创建HTTP请求
-	req, err := http.NewRequest("POST", apiURL, body)
+// UploadDocumentToTelegram 通过指定的机器人以文档形式上传文件到Telegram
+func UploadDocumentToTelegram(ctx context.Context, bot *TelegramBot, file io.Reader, filename string) (string, error) {
+	message, err := bot.client.SendDocument(ctx, file, filename)
 	if err != nil {
 		return "", err
 	}
+	return messageFileID(message)
+}
 
-	// 设置Content-Type
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// 解析响应
-	var telegramResp TelegramResponse
-	if err := json.NewDecoder(resp.Body).Decode(&telegramResp); err != nil {
-		return "", err
-	}
-
-	// 检查响应状态
-	if !telegramResp.Ok {
-		return "", fmt.Errorf("Telegram API错误: %s", telegramResp.Description)
-	}
-
-	// 解析消息
-	var message Message
-	if err := json.Unmarshal(telegramResp.Result, &message); err != nil {
-		return "", err
-	}
-
-	// 获取文件ID
-	var fileID string
+// messageFileID 获取消息中文件的file_id
+func messageFileID(message *Message) (string, error) {
 	if len(message.Photo) > 0 {
 		// 使用最大尺寸的图片
-		fileID = message.Photo[len(message.Photo)-1].FileID
+		return message.Photo[len(message.Photo)-1].FileID, nil
 	} else if message.Document != nil {
-		fileID = message.Document.FileID
-	} else {
-		return "", fmt.Errorf("未找到上传的文件ID")
+		return message.Document.FileID, nil
 	}
-
-	return fileID, nil
+	return "", fmt.Errorf("未找到上传的文件ID")
 }
 
-// GetTelegramImageURL 获取Telegram图片URL
-func GetTelegramImageURL(fileID string) (string, error) {
-	// 获取配置
-	botToken := viper.GetString("telegram.bot_token")
-
-	if botToken == "" {
-		return "", fmt.Errorf("Telegram配置不完整")
+// openTelegramFile 通过上传文件的机器人打开Telegram文件
+func openTelegramFile(ctx context.Context, bot *TelegramBot, fileID string) (*Object, error) {
+	file, err := getTelegramFile(ctx, bot, fileID)
+	if err != nil {
+		return nil, err
 	}
+	return bot.client.OpenFile(ctx, file)
+}
 
-	// 准备请求URL
-	apiURL := fmt.Sprintf(telegramAPIBaseURL+"/getFile", botToken)
-	apiURL = fmt.Sprintf("%s?file_id=%s", apiURL, fileID)
-
-	// 发送请求
-	resp, err := http.Get(apiURL)
+// openTelegramFileRange 通过上传文件的机器人读取Telegram文件的一部分
+func openTelegramFileRange(ctx context.Context, bot *TelegramBot, fileID string, offset, length int64) (*Object, error) {
+	file, err := getTelegramFile(ctx, bot, fileID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return bot.client.OpenFileRange(ctx, file, offset, length)
+}
 
-	// 解析响应
-	var telegramResp TelegramResponse
-	if err := json.NewDecoder(resp.Body).Decode(&telegramResp); err != nil {
-		return "", err
+// getTelegramFile 通过上传文件的机器人调用getFile获取文件信息，结果在有效期内缓存
+func getTelegramFile(ctx context.Context, bot *TelegramBot, fileID string) (*File, error) {
+	if file, ok := telegramFilePaths.get(fileID); ok {
+		return file, nil
 	}
 
-	// 检查响应状态
-	if !telegramResp.Ok {
-		return "", fmt.Errorf("Telegram API错误: %s", telegramResp.Description)
+	file, err := bot.client.GetFile(ctx, fileID)
+	bot.record(err)
+	if err != nil {
+		return nil, err
 	}
 
-	// 解析文件信息
-	var file File
-	if err := json.Unmarshal(telegramResp.Result, &file); err != nil {
-		return "", err
+	if file.FilePath != "" {
+		telegramFilePaths.set(fileID, file)
 	}
 
-	// 检查文件路径
-	if file.FilePath == "" {
-		return "", fmt.Errorf("未找到文件路径")
-	}
+	return file, nil
+}
 
-	// 构建文件URL
-	fileURL := fmt.Sprintf(telegramFileBaseURL, botToken, file.FilePath)
+// newTelegramClient 根据全局配置创建机器人的客户端
+func newTelegramClient(token, chatID string) (*TelegramClient, error) {
+	httpClient, err := telegramHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTelegramClient(TelegramConfig{
+		APIURL:        viper.GetString("telegram.api_url"),
+		Token:         token,
+		ChatID:        chatID,
+		LocalMode:     TelegramLocalMode(),
+		MaxRetries:    viper.GetInt("telegram.max_retries"),
+		MaxRetryAfter: viper.GetDuration("telegram.max_retry_after"),
+		HTTPClient:    httpClient,
+	})
+}
 
-	return fileURL, nil
-}
\ No newline at end of file
+// TelegramLocalMode 是否连接以--local模式运行的自建Bot API服务
+func TelegramLocalMode() bool {
+	return viper.GetBool("telegram.local_mode")
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// filePathCacheSweepSize 缓存条目超过该数量时写入前清理过期条目
+const filePathCacheSweepSize = 10000
+
+// FilePathCacheStats getFile结果缓存的统计信息
+type FilePathCacheStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Invalidations int64   `json:"invalidations"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Entries       int     `json:"entries"`
+}
+
+// filePathCache 缓存getFile返回的文件信息，Telegram保证file_path至少一小时内有效
+type filePathCache struct {
+	ttl func() time.Duration
+	now func() time.Time
+
+	mu            sync.Mutex
+	entries       map[string]filePathEntry
+	hits          int64
+	misses        int64
+	invalidations int64
+}
+
+type filePathEntry struct {
+	file    File
+	expires time.Time
+}
+
+func newFilePathCache(ttl func() time.Duration) *filePathCache {
+	return &filePathCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]filePathEntry),
+	}
+}
+
+// telegramFilePaths 全局getFile结果缓存
+var telegramFilePaths = newFilePathCache(func() time.Duration {
+	ttl := viper.GetDuration("telegram.file_path_ttl")
+	if ttl <= 0 {
+		ttl = 50 * time.Minute
+	}
+	return ttl
+})
+
+// get 获取未过期的缓存结果
+func (c *filePathCache) get(fileID string) (*File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[fileID]
+	if ok && c.now().Before(entry.expires) {
+		c.hits++
+		file := entry.file
+		return &file, true
+	}
+	if ok {
+		delete(c.entries, fileID)
+	}
+	c.misses++
+	return nil, false
+}
+
+// set 写入缓存结果
+func (c *filePathCache) set(fileID string, file *File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= filePathCacheSweepSize {
+		for id, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[fileID] = filePathEntry{file: *file, expires: now.Add(c.ttl())}
+}
+
+// invalidate 删除缓存结果，返回是否存在该条目
+func (c *filePathCache) invalidate(fileID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[fileID]; !ok {
+		return false
+	}
+	delete(c.entries, fileID)
+	c.invalidations++
+	return true
+}
+
+// stats 获取统计信息
+func (c *filePathCache) stats() FilePathCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := FilePathCacheStats{
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Invalidations: c.invalidations,
+		Entries:       len(c.entries),
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRatio = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// InvalidateTelegramFilePath 使某个文件的getFile缓存失效，下次访问时重新获取
+// 返回是否存在被删除的缓存条目
+func InvalidateTelegramFilePath(fileID string) bool {
+	return telegramFilePaths.invalidate(fileID)
+}
+
+// TelegramFilePathStats 获取getFile结果缓存的统计信息
+func TelegramFilePathStats() FilePathCacheStats {
+	return telegramFilePaths.stats()
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testBotToken = "123456:test-token"
+
+// fakeFileServer 模拟Bot API的getFile和文件下载接口
+// 每次getFile返回新的文件路径，被标记为过期的路径下载时返回404
+type fakeFileServer struct {
+	mu       sync.Mutex
+	getFiles int
+	version  int
+	expired  map[string]bool
+}
+
+func (f *fakeFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/bot"+testBotToken+"/getFile":
+		r.ParseForm()
+		f.getFiles++
+		f.version++
+		result, _ := json.Marshal(File{
+			FileID:   r.Form.Get("file_id"),
+			FilePath: fmt.Sprintf("photos/%s_v%d.jpg", r.Form.Get("file_id"), f.version),
+		})
+		json.NewEncoder(w).Encode(TelegramResponse{Ok: true, Result: result})
+	case strings.HasPrefix(r.URL.Path, "/file/bot"+testBotToken+"/"):
+		path := strings.TrimPrefix(r.URL.Path, "/file/bot"+testBotToken+"/")
+		if f.expired[path] {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "content of "+path)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeFileServer) expire(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expired[path] = true
+}
+
+func (f *fakeFileServer) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.getFiles
+}
+
+// useTestTelegramBot 将全局机器人池替换为连接到apiURL的单个机器人
+func useTestTelegramBot(t testing.TB, apiURL string) *TelegramBot {
+	t.Helper()
+	client, err := NewTelegramClient(TelegramConfig{APIURL: apiURL, Token: testBotToken, ChatID: "-100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := NewTelegramBot("test", client)
+	pool, err := NewTelegramBotPool([]*TelegramBot{bot}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	telegramBotsMu.Lock()
+	saved := telegramBots
+	telegramBots = pool
+	telegramBotsMu.Unlock()
+	t.Cleanup(func() {
+		telegramBotsMu.Lock()
+		telegramBots = saved
+		telegramBotsMu.Unlock()
+	})
+	return bot
+}
+
+// useTestFilePathCache 将全局getFile缓存替换为使用可控时钟的缓存
+func useTestFilePathCache(t *testing.T, ttl time.Duration) (*filePathCache, *time.Time) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newFilePathCache(func() time.Duration { return ttl })
+	cache.now = func() time.Time { return now }
+
+	saved := telegramFilePaths
+	telegramFilePaths = cache
+	t.Cleanup(func() { telegramFilePaths = saved })
+	return cache, &now
+}
+
+func TestFilePathCacheTTL(t *testing.T) {
+	fake := &fakeFileServer{expired: map[string]bool{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	bot := useTestTelegramBot(t, srv.URL)
+	cache, now := useTestFilePathCache(t, time.Minute)
+	ctx := context.Background()
+
+	first, err := getTelegramFile(ctx, bot, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := getTelegramFile(ctx, bot, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls() != 1 || second.FilePath != first.FilePath {
+		t.Fatalf("second lookup should hit the cache: getFile calls = %d, paths %q / %q", fake.calls(), first.FilePath, second.FilePath)
+	}
+
+	// 到期前仍然命中
+	*now = now.Add(59 * time.Second)
+	if _, err := getTelegramFile(ctx, bot, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls() != 1 {
+		t.Fatalf("lookup before expiry should hit the cache, getFile calls = %d", fake.calls())
+	}
+
+	// 到期后重新调用getFile
+	*now = now.Add(time.Second)
+	third, err := getTelegramFile(ctx, bot, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls() != 2 || third.FilePath == first.FilePath {
+		t.Fatalf("expired entry should be refreshed: getFile calls = %d, path %q", fake.calls(), third.FilePath)
+	}
+
+	stats := cache.stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Entries != 1 || stats.HitRatio != 0.5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFilePathCacheInvalidatedOn4xx(t *testing.T) {
+	fake := &fakeFileServer{expired: map[string]bool{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	useTestTelegramBot(t, srv.URL)
+	cache, _ := useTestFilePathCache(t, time.Hour)
+	st := NewTelegramStorage().ForBot("")
+	ctx := context.Background()
+
+	readAll := func() (string, error) {
+		object, err := st.Get(ctx, "abc")
+		if err != nil {
+			return "", err
+		}
+		defer object.Body.Close()
+		data, err := io.ReadAll(object.Body)
+		return string(data), err
+	}
+
+	body, err := readAll()
+	if err != nil || body != "content of photos/abc_v1.jpg" {
+		t.Fatalf("first read = %q, %v", body, err)
+	}
+
+	// 缓存的路径在TTL内失效，下载返回404后删除缓存并重新获取
+	fake.expire("photos/abc_v1.jpg")
+	body, err = readAll()
+	if err != nil || body != "content of photos/abc_v2.jpg" {
+		t.Fatalf("read after expiry = %q, %v", body, err)
+	}
+	if fake.calls() != 2 {
+		t.Fatalf("getFile calls = %d, want 2", fake.calls())
+	}
+
+	stats := cache.stats()
+	if stats.Invalidations != 1 || stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 重新获取的路径仍然失效时不再重试
+	fake.expire("photos/abc_v2.jpg")
+	fake.expire("photos/abc_v3.jpg")
+	if _, err := readAll(); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("read with dead path: got %v, want ErrObjectNotFound", err)
+	}
+	if fake.calls() != 3 {
+		t.Fatalf("getFile calls = %d, want 3", fake.calls())
+	}
+}
+
+func TestFilePathCacheInvalidate(t *testing.T) {
+	cache := newFilePathCache(func() time.Duration { return time.Hour })
+	if _, ok := cache.get("abc"); ok {
+		t.Fatal("empty cache should miss")
+	}
+	cache.set("abc", &File{FileID: "abc", FilePath: "photos/a.jpg"})
+	if file, ok := cache.get("abc"); !ok || file.FilePath != "photos/a.jpg" {
+		t.Fatalf("get after set = %+v, %v", file, ok)
+	}
+	if !cache.invalidate("abc") || cache.invalidate("abc") {
+		t.Fatal("invalidate should report whether an entry was removed")
+	}
+	if stats := cache.stats(); stats.Invalidations != 1 || stats.Entries != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
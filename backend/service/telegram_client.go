@@ -0,0 +1,347 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TelegramConfig Telegram客户端配置
+type TelegramConfig struct {
+	// APIURL Bot API服务地址，为空时使用官方地址
+	APIURL string
+	Token  string
+	ChatID string
+	// LocalMode Bot API服务以--local模式运行，getFile返回磁盘上的绝对路径
+	LocalMode bool
+	// MaxRetries 网络错误、5xx和429时的最大重试次数
+	MaxRetries int
+	// MaxRetryAfter 触发频率限制时愿意等待的最长时间，为空时为60秒
+	MaxRetryAfter time.Duration
+	// HTTPClient 发送请求使用的客户端，为空时使用http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// TelegramClient 单个机器人的Bot API客户端
+type TelegramClient struct {
+	cfg    TelegramConfig
+	client *http.Client
+}
+
+// NewTelegramClient 根据配置创建客户端
+func NewTelegramClient(cfg TelegramConfig) (*TelegramClient, error) {
+	if cfg.Token == "" || cfg.ChatID == "" {
+		return nil, fmt.Errorf("Telegram配置不完整")
+	}
+	if id, _, ok := strings.Cut(cfg.Token, ":"); !ok || id == "" {
+		return nil, fmt.Errorf("Bot Token格式错误")
+	}
+
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	if cfg.APIURL == "" {
+		cfg.APIURL = defaultTelegramAPIURL
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.MaxRetryAfter <= 0 {
+		cfg.MaxRetryAfter = 60 * time.Second
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &TelegramClient{cfg: cfg, client: client}, nil
+}
+
+// BotID 机器人的用户ID，即Bot Token中冒号前的数字部分
+func (c *TelegramClient) BotID() string {
+	id, _, _ := strings.Cut(c.cfg.Token, ":")
+	return id
+}
+
+// ChatID 发送文件的目标聊天
+func (c *TelegramClient) ChatID() string {
+	return c.cfg.ChatID
+}
+
+// SendPhoto 以图片消息发送文件，Telegram会压缩并生成多个尺寸
+func (c *TelegramClient) SendPhoto(ctx context.Context, r io.Reader, filename string) (*Message, error) {
+	return c.sendFile(ctx, "sendPhoto", "photo", r, filename)
+}
+
+// SendDocument 以文档消息发送文件，保留原始文件内容
+func (c *TelegramClient) SendDocument(ctx context.Context, r io.Reader, filename string) (*Message, error) {
+	return c.sendFile(ctx, "sendDocument", "document", r, filename)
+}
+
+// GetFile 调用getFile获取文件信息
+func (c *TelegramClient) GetFile(ctx context.Context, fileID string) (*File, error) {
+	form := url.Values{"file_id": {fileID}}
+	result, err := c.call(ctx, "getFile", func() (io.Reader, string, error) {
+		return strings.NewReader(form.Encode()), "application/x-www-form-urlencoded", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析文件信息
+	var file File
+	if err := json.Unmarshal(result, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// FileURL 文件的下载地址，本地模式下为磁盘上的绝对路径
+func (c *TelegramClient) FileURL(file *File) string {
+	if c.localPath(file) {
+		return "file://" + filepath.ToSlash(file.FilePath)
+	}
+	return fmt.Sprintf("%s/file/bot%s/%s", c.cfg.APIURL, c.cfg.Token, file.FilePath)
+}
+
+// OpenFile 打开getFile返回的文件，调用方负责关闭返回的Body
+// 本地模式下直接读取磁盘上的文件，否则通过文件下载地址获取
+// 文件路径失效时返回errFilePathExpired
+func (c *TelegramClient) OpenFile(ctx context.Context, file *File) (*Object, error) {
+	return c.OpenFileRange(ctx, file, 0, -1)
+}
+
+// OpenFileRange 打开文件从offset开始的length字节，length小于0时读取到末尾，Size为返回内容的长度
+// 通过下载地址获取时使用Range请求，只下载需要的部分
+func (c *TelegramClient) OpenFileRange(ctx context.Context, file *File, offset, length int64) (*Object, error) {
+	ranged := offset > 0 || length >= 0
+	if file.FilePath == "" {
+		return nil, fmt.Errorf("未找到文件路径")
+	}
+
+	if c.localPath(file) {
+		f, err := os.Open(file.FilePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: %s", errFilePathExpired, file.FilePath)
+			}
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		size := max(fi.Size()-offset, 0)
+		if length >= 0 {
+			size = min(size, length)
+		}
+		body, _ := limitBody(f, 0, length)
+		return &Object{
+			ObjectInfo: ObjectInfo{
+				Key:         file.FileID,
+				Size:        size,
+				ContentType: mime.TypeByExtension(filepath.Ext(file.FilePath)),
+				ModTime:     fi.ModTime(),
+			},
+			Body: body,
+		}, nil
+	}
+
+	fileURL := c.FileURL(file)
+
+	var resp *http.Response
+	err := c.retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+		if err != nil {
+			return err
+		}
+		if ranged {
+			req.Header.Set("Range", rangeHeader(offset, length))
+		}
+
+		resp, err = c.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK && !(ranged && resp.StatusCode == http.StatusPartialContent) {
+			resp.Body.Close()
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return fmt.Errorf("%w: HTTP %d", errFilePathExpired, resp.StatusCode)
+			}
+			return &TelegramAPIError{Code: resp.StatusCode, Description: fmt.Sprintf("下载文件失败: HTTP %d", resp.StatusCode)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	size, body := resp.ContentLength, io.ReadCloser(resp.Body)
+	if ranged {
+		if resp.StatusCode != http.StatusPartialContent {
+			size = -1
+		}
+		if body, err = rangeBody(resp, offset, length); err != nil {
+			return nil, err
+		}
+	}
+	return &Object{
+		ObjectInfo: ObjectInfo{
+			Key:         file.FileID,
+			Size:        size,
+			ContentType: resp.Header.Get("Content-Type"),
+		},
+		Body: body,
+	}, nil
+}
+
+// localPath 文件路径是否为本地模式下的磁盘路径
+func (c *TelegramClient) localPath(file *File) bool {
+	return c.cfg.LocalMode && filepath.IsAbs(file.FilePath)
+}
+
+// sendFile 调用sendPhoto/sendDocument等接口发送文件到目标聊天
+// 文件内容通过io.Pipe边读取边发送，不在内存中构建完整的请求体
+// r支持Seek时每次重试从原位置重新读取，否则先读入内存
+func (c *TelegramClient) sendFile(ctx context.Context, method, field string, r io.Reader, filename string) (*Message, error) {
+	file, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		file = bytes.NewReader(data)
+	}
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	var done chan struct{}
+	result, err := c.call(ctx, method, func() (io.Reader, string, error) {
+		// 上一次请求的写入结束后才能重新定位，Transport关闭请求体后写入会立即失败
+		if done != nil {
+			<-done
+		}
+		if _, err := file.Seek(start, io.SeekStart); err != nil {
+			return nil, "", err
+		}
+
+		pr, pw := io.Pipe()
+		writer := multipart.NewWriter(pw)
+		done = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			pw.CloseWithError(writeFileForm(writer, c.cfg.ChatID, field, file, filename))
+		}(done)
+		return pr, writer.FormDataContentType(), nil
+	})
+	if done != nil {
+		<-done
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析消息
+	var message Message
+	if err := json.Unmarshal(result, &message); err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// writeFileForm 写入发送文件的multipart请求体
+func writeFileForm(writer *multipart.Writer, chatID, field string, r io.Reader, filename string) error {
+	// 添加chat_id字段
+	if err := writer.WriteField("chat_id", chatID); err != nil {
+		return err
+	}
+
+	// 添加文件
+	part, err := writer.CreateFormFile(field, filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+
+	// 复制文件内容
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+
+	// 完成multipart写入
+	return writer.Close()
+}
+
+// call 调用Bot API方法并返回result字段，失败时按重试策略重试
+// body在每次请求时调用，返回请求体和Content-Type
+func (c *TelegramClient) call(ctx context.Context, method string, body func() (io.Reader, string, error)) (json.RawMessage, error) {
+	// 准备请求URL
+	apiURL := fmt.Sprintf("%s/bot%s/%s", c.cfg.APIURL, c.cfg.Token, method)
+
+	var result json.RawMessage
+	err := c.retry(ctx, func() error {
+		r, contentType, err := body()
+		if err != nil {
+			return err
+		}
+
+		// 创建HTTP请求
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, r)
+		if err != nil {
+			if closer, ok := r.(io.Closer); ok {
+				closer.Close()
+			}
+			return err
+		}
+
+		// 设置Content-Type
+		req.Header.Set("Content-Type", contentType)
+
+		// 发送请求，请求体由Transport关闭
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// 解析响应
+		var telegramResp TelegramResponse
+		if err := json.NewDecoder(resp.Body).Decode(&telegramResp); err != nil {
+			// 反向代理等返回的非JSON错误页
+			if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+				return &TelegramAPIError{Code: resp.StatusCode, Description: http.StatusText(resp.StatusCode)}
+			}
+			return err
+		}
+
+		// 检查响应状态
+		if !telegramResp.Ok {
+			apiErr := &TelegramAPIError{Code: telegramResp.ErrorCode, Description: telegramResp.Description}
+			if telegramResp.Parameters != nil {
+				apiErr.RetryAfter = time.Duration(telegramResp.Parameters.RetryAfter) * time.Second
+			}
+			return apiErr
+		}
+
+		result = telegramResp.Result
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// telegramMethod 被测试的客户端方法，返回结果的描述用于校验
+type telegramMethod struct {
+	name   string
+	call   func(ctx context.Context, c *TelegramClient) (string, error)
+	result string
+	want   string
+}
+
+var telegramMethods = []telegramMethod{
+	{
+		name: "sendPhoto",
+		call: func(ctx context.Context, c *TelegramClient) (string, error) {
+			message, err := c.SendPhoto(ctx, strings.NewReader("photo-bytes"), "dir/cat.jpg")
+			if err != nil {
+				return "", err
+			}
+			return messageFileID(message)
+		},
+		result: `{"message_id":1,"photo":[{"file_id":"small","width":90,"height":90},{"file_id":"large","width":800,"height":800}]}`,
+		want:   "large",
+	},
+	{
+		name: "sendDocument",
+		call: func(ctx context.Context, c *TelegramClient) (string, error) {
+			message, err := c.SendDocument(ctx, strings.NewReader("document-bytes"), "cat.png")
+			if err != nil {
+				return "", err
+			}
+			return messageFileID(message)
+		},
+		result: `{"message_id":2,"document":{"file_id":"doc","file_name":"cat.png"}}`,
+		want:   "doc",
+	},
+	{
+		name: "getFile",
+		call: func(ctx context.Context, c *TelegramClient) (string, error) {
+			file, err := c.GetFile(ctx, "abc")
+			if err != nil {
+				return "", err
+			}
+			return file.FilePath, nil
+		},
+		result: `{"file_id":"abc","file_path":"photos/file_1.jpg"}`,
+		want:   "photos/file_1.jpg",
+	},
+}
+
+// checkTelegramRequest 校验请求的路径和请求体
+func checkTelegramRequest(t *testing.T, r *http.Request, method string) {
+	t.Helper()
+	if r.Method != http.MethodPost || r.URL.Path != "/bot"+testBotToken+"/"+method {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		return
+	}
+
+	switch method {
+	case "getFile":
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("file_id") != "abc" {
+			t.Errorf("getFile form = %v, %v", r.PostForm, err)
+		}
+	default:
+		field, content, filename := "photo", "photo-bytes", "cat.jpg"
+		if method == "sendDocument" {
+			field, content, filename = "document", "document-bytes", "cat.png"
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse multipart: %v", err)
+			return
+		}
+		if got := r.FormValue("chat_id"); got != "-100" {
+			t.Errorf("chat_id = %q", got)
+		}
+		f, header, err := r.FormFile(field)
+		if err != nil {
+			t.Errorf("form file %s: %v", field, err)
+			return
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		if string(data) != content || header.Filename != filename {
+			t.Errorf("file %q (%q), want %q (%q)", data, header.Filename, content, filename)
+		}
+	}
+}
+
+// newTestTelegramClient 创建连接到测试服务的客户端
+func newTestTelegramClient(t *testing.T, handler http.HandlerFunc, maxRetries int) (*TelegramClient, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewTelegramClient(TelegramConfig{
+		APIURL:     srv.URL + "/",
+		Token:      testBotToken,
+		ChatID:     "-100",
+		MaxRetries: maxRetries,
+		HTTPClient: srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &calls
+}
+
+func TestTelegramClientOK(t *testing.T) {
+	for _, m := range telegramMethods {
+		t.Run(m.name, func(t *testing.T) {
+			client, calls := newTestTelegramClient(t, func(w http.ResponseWriter, r *http.Request) {
+				checkTelegramRequest(t, r, m.name)
+				io.WriteString(w, `{"ok":true,"result":`+m.result+`}`)
+			}, 0)
+
+			got, err := m.call(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != m.want || atomic.LoadInt32(calls) != 1 {
+				t.Fatalf("got %q after %d calls, want %q after 1", got, atomic.LoadInt32(calls), m.want)
+			}
+		})
+	}
+}
+
+func TestTelegramClientErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		body    string
+		code    int
+		desc    string
+		calls   int32
+		apiErr  bool
+		jsonErr bool
+	}{
+		{
+			name:   "ok false",
+			status: http.StatusOK,
+			body:   `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`,
+			code:   400,
+			desc:   "Bad Request: chat not found",
+			calls:  1,
+			apiErr: true,
+		},
+		{
+			name:   "4xx json",
+			status: http.StatusForbidden,
+			body:   `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+			code:   403,
+			desc:   "Forbidden: bot was blocked by the user",
+			calls:  1,
+			apiErr: true,
+		},
+		{
+			name:    "4xx html",
+			status:  http.StatusNotFound,
+			body:    `<html>404 Not Found</html>`,
+			calls:   1,
+			jsonErr: true,
+		},
+		{
+			// 5xx按重试次数重试，最后返回带状态码的错误
+			name:   "5xx html",
+			status: http.StatusBadGateway,
+			body:   `<html>502 Bad Gateway</html>`,
+			code:   502,
+			desc:   "Bad Gateway",
+			calls:  2,
+			apiErr: true,
+		},
+		{
+			name:   "5xx json",
+			status: http.StatusInternalServerError,
+			body:   `{"ok":false,"error_code":500,"description":"Internal Server Error"}`,
+			code:   500,
+			desc:   "Internal Server Error",
+			calls:  2,
+			apiErr: true,
+		},
+		{
+			name:    "malformed json",
+			status:  http.StatusOK,
+			body:    `{"ok":true,"result":`,
+			calls:   1,
+			jsonErr: true,
+		},
+		{
+			name:    "malformed result",
+			status:  http.StatusOK,
+			body:    `{"ok":true,"result":"not an object"}`,
+			calls:   1,
+			jsonErr: true,
+		},
+	} {
+		for _, m := range telegramMethods {
+			t.Run(tc.name+"/"+m.name, func(t *testing.T) {
+				client, calls := newTestTelegramClient(t, func(w http.ResponseWriter, r *http.Request) {
+					// 重试时请求体应完整重新发送
+					checkTelegramRequest(t, r, m.name)
+					w.WriteHeader(tc.status)
+					io.WriteString(w, tc.body)
+				}, 1)
+
+				_, err := m.call(context.Background(), client)
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if atomic.LoadInt32(calls) != tc.calls {
+					t.Errorf("server saw %d calls, want %d", atomic.LoadInt32(calls), tc.calls)
+				}
+
+				var apiErr *TelegramAPIError
+				if tc.apiErr {
+					if !errors.As(err, &apiErr) || apiErr.Code != tc.code || apiErr.Description != tc.desc {
+						t.Fatalf("got %#v, want TelegramAPIError{%d, %q}", err, tc.code, tc.desc)
+					}
+				}
+				if tc.jsonErr {
+					var syntaxErr *json.SyntaxError
+					var typeErr *json.UnmarshalTypeError
+					decodeErr := errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.ErrUnexpectedEOF)
+					if errors.As(err, &apiErr) || !decodeErr {
+						t.Fatalf("got %#v, want a JSON decoding error", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTelegramClientRetryAfter(t *testing.T) {
+	for _, m := range telegramMethods {
+		t.Run(m.name, func(t *testing.T) {
+			var attempt int32
+			client, calls := newTestTelegramClient(t, func(w http.ResponseWriter, r *http.Request) {
+				checkTelegramRequest(t, r, m.name)
+				if atomic.AddInt32(&attempt, 1) == 1 {
+					w.WriteHeader(http.StatusTooManyRequests)
+					io.WriteString(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`)
+					return
+				}
+				io.WriteString(w, `{"ok":true,"result":`+m.result+`}`)
+			}, 1)
+
+			start := time.Now()
+			got, err := m.call(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != m.want || atomic.LoadInt32(calls) != 2 {
+				t.Fatalf("got %q after %d calls, want %q after 2", got, atomic.LoadInt32(calls), m.want)
+			}
+			if elapsed := time.Since(start); elapsed < time.Second {
+				t.Fatalf("retried after %v, want at least retry_after", elapsed)
+			}
+		})
+	}
+}
+
+func TestTelegramClientContextCanceled(t *testing.T) {
+	for _, m := range telegramMethods {
+		t.Run(m.name, func(t *testing.T) {
+			received := make(chan struct{})
+			client, calls := newTestTelegramClient(t, func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				close(received)
+				// 等待客户端断开
+				<-r.Context().Done()
+			}, 3)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-received
+				cancel()
+			}()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := m.call(ctx, client)
+				done <- err
+			}()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("got %v, want context.Canceled", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("call did not return after the context was canceled")
+			}
+			if atomic.LoadInt32(calls) != 1 {
+				t.Fatalf("canceled request should not be retried, server saw %d calls", atomic.LoadInt32(calls))
+			}
+		})
+	}
+}
+
+func TestTelegramClientCanceledDuringBackoff(t *testing.T) {
+	client, calls := newTestTelegramClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := client.GetFile(ctx, "abc")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("server saw %d calls, want 1", atomic.LoadInt32(calls))
+	}
+}
@@ -0,0 +1,409 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// 机器人选择策略
+const (
+	// BotSelectRoundRobin 依次轮流使用各机器人
+	BotSelectRoundRobin = "round_robin"
+	// BotSelectLeastLoaded 使用正在进行的上传最少的机器人
+	BotSelectLeastLoaded = "least_loaded"
+)
+
+const (
+	// botFailureThreshold 连续失败达到该次数后暂停使用机器人
+	botFailureThreshold = 3
+	// botCooldown 首次暂停的时长，之后每次连续失败翻倍
+	botCooldown = 30 * time.Second
+	// botMaxCooldown 暂停的最长时长
+	botMaxCooldown = 10 * time.Minute
+)
+
+// TelegramBotConfig 机器人配置
+type TelegramBotConfig struct {
+	Name   string `mapstructure:"name"`
+	Token  string `mapstructure:"token"`
+	ChatID string `mapstructure:"chat_id"`
+}
+
+// TelegramBot 机器人池中的机器人，记录其健康状态
+// file_id只对上传它的机器人有效，文件记录中保存机器人ID以便下载时选择对应的机器人
+type TelegramBot struct {
+	// ID Bot Token中冒号前的数字部分，即机器人的用户ID，不包含密钥
+	ID   string
+	Name string
+
+	client *TelegramClient
+	now    func() time.Time
+
+	mu            sync.Mutex
+	inflight      int
+	failures      int
+	disabledUntil time.Time
+	uploads       int64
+	errCount      int64
+	lastError     string
+}
+
+// TelegramBotStats 机器人的运行状态
+type TelegramBotStats struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	ChatID        string     `json:"chat_id"`
+	Healthy       bool       `json:"healthy"`
+	Inflight      int        `json:"inflight"`
+	Uploads       int64      `json:"uploads"`
+	Errors        int64      `json:"errors"`
+	Failures      int        `json:"consecutive_failures"`
+	DisabledUntil *time.Time `json:"disabled_until,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// NewTelegramBot 创建机器人，name为空时使用机器人ID
+func NewTelegramBot(name string, client *TelegramClient) *TelegramBot {
+	if name == "" {
+		name = client.BotID()
+	}
+	return &TelegramBot{
+		ID:     client.BotID(),
+		Name:   name,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// healthy 机器人当前是否可用于上传
+func (b *TelegramBot) healthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.now().Before(b.disabledUntil)
+}
+
+// load 正在进行的上传数
+func (b *TelegramBot) load() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inflight
+}
+
+// record 记录一次请求的结果，连续失败或鉴权失败时暂停使用该机器人
+func (b *TelegramBot) record(err error) {
+	if err == nil {
+		b.mu.Lock()
+		b.failures = 0
+		b.mu.Unlock()
+		return
+	}
+
+	if !isBotFailure(err) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.errCount++
+	b.lastError = err.Error()
+
+	var apiErr *TelegramAPIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests && apiErr.RetryAfter > 0 {
+		// 触发频率限制，限制解除前上传使用其他机器人
+		if until := b.now().Add(apiErr.RetryAfter); until.After(b.disabledUntil) {
+			b.disabledUntil = until
+		}
+		return
+	}
+
+	b.failures++
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden) {
+		// Token失效或机器人被移出聊天，需要人工处理
+		b.disabledUntil = b.now().Add(botMaxCooldown)
+		log.Printf("Telegram机器人%s鉴权失败，暂停使用: %v", b.Name, err)
+		return
+	}
+
+	if b.failures >= botFailureThreshold {
+		cooldown := botCooldownFor(b.failures)
+		b.disabledUntil = b.now().Add(cooldown)
+		log.Printf("Telegram机器人%s连续失败%d次，暂停使用%s: %v", b.Name, b.failures, cooldown, err)
+	}
+}
+
+// botCooldownFor 连续失败failures次后暂停使用的时长，每次翻倍，不超过botMaxCooldown
+func botCooldownFor(failures int) time.Duration {
+	cooldown := botCooldown
+	for i := botFailureThreshold; i < failures && cooldown < botMaxCooldown; i++ {
+		cooldown *= 2
+	}
+	return min(cooldown, botMaxCooldown)
+}
+
+// stats 获取运行状态
+func (b *TelegramBot) stats() TelegramBotStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := TelegramBotStats{
+		ID:        b.ID,
+		Name:      b.Name,
+		ChatID:    b.client.ChatID(),
+		Healthy:   !b.now().Before(b.disabledUntil),
+		Inflight:  b.inflight,
+		Uploads:   b.uploads,
+		Errors:    b.errCount,
+		Failures:  b.failures,
+		LastError: b.lastError,
+	}
+	if !stats.Healthy {
+		until := b.disabledUntil
+		stats.DisabledUntil = &until
+	}
+	return stats
+}
+
+// isBotFailure 错误是否由机器人或Telegram服务引起
+// 请求参数错误（如图片尺寸不合法）和调用方取消不计入机器人的失败次数
+func isBotFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *TelegramAPIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+		return strings.Contains(strings.ToLower(apiErr.Description), "chat not found")
+	}
+	return true
+}
+
+// TelegramBotPool 机器人池，上传时按策略选择机器人以分摊各机器人的频率限制
+type TelegramBotPool struct {
+	bots     []*TelegramBot
+	byID     map[string]*TelegramBot
+	strategy string
+	next     atomic.Uint64
+}
+
+// NewTelegramBotPool 创建机器人池，第一个机器人为主机器人，未记录机器人的早期文件使用主机器人下载
+func NewTelegramBotPool(bots []*TelegramBot, strategy string) (*TelegramBotPool, error) {
+	if len(bots) == 0 {
+		return nil, fmt.Errorf("Telegram配置不完整")
+	}
+
+	switch strategy {
+	case "":
+		strategy = BotSelectRoundRobin
+	case BotSelectRoundRobin, BotSelectLeastLoaded:
+	default:
+		return nil, fmt.Errorf("未知的机器人选择策略: %s", strategy)
+	}
+
+	p := &TelegramBotPool{
+		byID:     make(map[string]*TelegramBot, len(bots)),
+		strategy: strategy,
+	}
+	for _, bot := range bots {
+		if _, ok := p.byID[bot.ID]; ok {
+			return nil, fmt.Errorf("重复的Telegram机器人: %s", bot.ID)
+		}
+		p.byID[bot.ID] = bot
+		p.bots = append(p.bots, bot)
+	}
+	return p, nil
+}
+
+// Acquire 选择一个用于上传的机器人，使用完毕后需调用Release
+// 所有机器人都被暂停时选择最早恢复的机器人，避免上传完全不可用
+func (p *TelegramBotPool) Acquire() *TelegramBot {
+	var candidates []*TelegramBot
+	for _, bot := range p.bots {
+		if bot.healthy() {
+			candidates = append(candidates, bot)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = []*TelegramBot{p.earliestRecovery()}
+	}
+
+	var bot *TelegramBot
+	switch p.strategy {
+	case BotSelectLeastLoaded:
+		// 负载相同时轮流选择，避免总是使用同一个机器人
+		start := int(p.next.Add(1) % uint64(len(candidates)))
+		for i := range candidates {
+			c := candidates[(start+i)%len(candidates)]
+			if bot == nil || c.load() < bot.load() {
+				bot = c
+			}
+		}
+	default:
+		bot = candidates[p.next.Add(1)%uint64(len(candidates))]
+	}
+
+	bot.mu.Lock()
+	bot.inflight++
+	bot.mu.Unlock()
+	return bot
+}
+
+// Release 归还Acquire选择的机器人并记录上传结果
+func (p *TelegramBotPool) Release(bot *TelegramBot, err error) {
+	bot.mu.Lock()
+	bot.inflight--
+	if err == nil {
+		bot.uploads++
+	}
+	bot.mu.Unlock()
+	bot.record(err)
+}
+
+// Get 根据ID获取机器人，ID为空时返回主机器人
+func (p *TelegramBotPool) Get(id string) (*TelegramBot, error) {
+	if id == "" {
+		return p.bots[0], nil
+	}
+	bot, ok := p.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("未配置Telegram机器人: %s", id)
+	}
+	return bot, nil
+}
+
+// Stats 获取各机器人的运行状态
+func (p *TelegramBotPool) Stats() []TelegramBotStats {
+	stats := make([]TelegramBotStats, 0, len(p.bots))
+	for _, bot := range p.bots {
+		stats = append(stats, bot.stats())
+	}
+	return stats
+}
+
+// earliestRecovery 最早恢复可用的机器人
+func (p *TelegramBotPool) earliestRecovery() *TelegramBot {
+	var earliest *TelegramBot
+	var until time.Time
+	for _, bot := range p.bots {
+		bot.mu.Lock()
+		t := bot.disabledUntil
+		bot.mu.Unlock()
+		if earliest == nil || t.Before(until) {
+			earliest, until = bot, t
+		}
+	}
+	return earliest
+}
+
+var (
+	telegramBotsMu sync.Mutex
+	telegramBots   *TelegramBotPool
+)
+
+// InitTelegramBots 根据配置初始化机器人池
+// telegram.bot_token和telegram.chat_id作为主机器人，telegram.bots中的机器人依次加入
+func InitTelegramBots() error {
+	pool, err := loadTelegramBots()
+	if err != nil {
+		return err
+	}
+
+	telegramBotsMu.Lock()
+	telegramBots = pool
+	telegramBotsMu.Unlock()
+	return nil
+}
+
+// loadTelegramBots 读取机器人配置
+func loadTelegramBots() (*TelegramBotPool, error) {
+	configs := []TelegramBotConfig{{
+		Token:  viper.GetString("telegram.bot_token"),
+		ChatID: viper.GetString("telegram.chat_id"),
+	}}
+
+	var extra []TelegramBotConfig
+	if err := viper.UnmarshalKey("telegram.bots", &extra); err != nil {
+		return nil, fmt.Errorf("读取telegram.bots配置失败: %w", err)
+	}
+	configs = append(configs, extra...)
+
+	var bots []*TelegramBot
+	seen := make(map[string]bool)
+	for i, cfg := range configs {
+		if cfg.Token == "" && cfg.ChatID == "" {
+			continue
+		}
+		client, err := newTelegramClient(cfg.Token, cfg.ChatID)
+		if err != nil {
+			// 未修改的默认配置不阻止启动，上传时再报错
+			log.Printf("忽略第%d个Telegram机器人配置(%s): %v", i+1, tokenDigest(cfg.Token), err)
+			continue
+		}
+		bot := NewTelegramBot(cfg.Name, client)
+		if seen[bot.ID] {
+			continue
+		}
+		seen[bot.ID] = true
+		bots = append(bots, bot)
+	}
+
+	if len(bots) == 0 {
+		return nil, nil
+	}
+	return NewTelegramBotPool(bots, viper.GetString("telegram.bot_selection"))
+}
+
+// telegramBotPool 获取机器人池，未初始化时从配置加载
+func telegramBotPool() (*TelegramBotPool, error) {
+	telegramBotsMu.Lock()
+	defer telegramBotsMu.Unlock()
+
+	if telegramBots == nil {
+		pool, err := loadTelegramBots()
+		if err != nil {
+			return nil, err
+		}
+		telegramBots = pool
+	}
+	if telegramBots == nil {
+		return nil, fmt.Errorf("Telegram配置不完整")
+	}
+	return telegramBots, nil
+}
+
+// GetTelegramBot 根据ID获取机器人，ID为空时返回主机器人
+func GetTelegramBot(id string) (*TelegramBot, error) {
+	pool, err := telegramBotPool()
+	if err != nil {
+		return nil, err
+	}
+	return pool.Get(id)
+}
+
+// TelegramBotStatus 获取各机器人的运行状态
+func TelegramBotStatus() []TelegramBotStats {
+	pool, err := telegramBotPool()
+	if err != nil {
+		return []TelegramBotStats{}
+	}
+	return pool.Stats()
+}
+
+// tokenDigest 用于日志的Token摘要，避免输出密钥
+func tokenDigest(token string) string {
+	if token == "" {
+		return "空"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClock 测试用的可调整时钟
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time { return c.t }
+
+// newTestBots 创建ID为1到n的机器人，共用clock
+func newTestBots(t *testing.T, n int, clock *testClock) []*TelegramBot {
+	t.Helper()
+	bots := make([]*TelegramBot, n)
+	for i := range bots {
+		client, err := NewTelegramClient(TelegramConfig{Token: fmt.Sprintf("%d:secret", i+1), ChatID: "-100"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bots[i] = NewTelegramBot("", client)
+		bots[i].now = clock.now
+	}
+	return bots
+}
+
+// acquireSequence 依次选择n次机器人，返回各次选择的机器人ID，release为true时每次选择后立即归还
+func acquireSequence(pool *TelegramBotPool, n int, release bool) string {
+	ids := make([]string, n)
+	for i := range ids {
+		bot := pool.Acquire()
+		ids[i] = bot.ID
+		if release {
+			pool.Release(bot, nil)
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestTelegramBotPoolAcquire(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// inflight 各机器人已有的上传数
+		inflight []int
+		// disabled 暂停使用的机器人及恢复前的时长
+		disabled map[int]time.Duration
+		release  bool
+		want     string
+	}{
+		{"round robin cycles", BotSelectRoundRobin, nil, nil, true, "2,3,1,2,3,1"},
+		{"round robin ignores load", BotSelectRoundRobin, []int{0, 5, 0}, nil, true, "2,3,1"},
+		{"round robin skips disabled", "", nil, map[int]time.Duration{1: time.Minute}, true, "3,1,3,1"},
+		{"least loaded picks idle", BotSelectLeastLoaded, []int{2, 0, 1}, nil, true, "2,2,2"},
+		{"least loaded rotates ties", BotSelectLeastLoaded, nil, nil, true, "2,3,1,2"},
+		{"least loaded counts inflight", BotSelectLeastLoaded, []int{1, 1, 0}, nil, false, "3,3,1,2"},
+		{"least loaded skips disabled", BotSelectLeastLoaded, []int{0, 3, 3}, map[int]time.Duration{0: time.Minute}, true, "3,2,3"},
+		{"all disabled uses earliest recovery", BotSelectRoundRobin, nil, map[int]time.Duration{0: time.Hour, 1: time.Minute, 2: 2 * time.Minute}, true, "2,2"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &testClock{t: time.Unix(1700000000, 0)}
+			bots := newTestBots(t, 3, clock)
+			for i, n := range tc.inflight {
+				bots[i].inflight = n
+			}
+			for i, d := range tc.disabled {
+				bots[i].disabledUntil = clock.t.Add(d)
+			}
+			pool, err := NewTelegramBotPool(bots, tc.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := acquireSequence(pool, len(strings.Split(tc.want, ",")), tc.release); got != tc.want {
+				t.Fatalf("acquired %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTelegramBotPoolReleaseCounts(t *testing.T) {
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	pool, err := NewTelegramBotPool(newTestBots(t, 1, clock), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := pool.Acquire(), pool.Acquire()
+	if got := a.load(); got != 2 {
+		t.Fatalf("inflight %d, want 2", got)
+	}
+	pool.Release(a, nil)
+	pool.Release(b, errors.New("connection reset"))
+
+	stats := pool.Stats()[0]
+	if stats.Inflight != 0 || stats.Uploads != 1 || stats.Errors != 1 || stats.Failures != 1 || stats.LastError != "connection reset" {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestTelegramBotRecord(t *testing.T) {
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name string
+		errs []error
+		// wantDisabled 最后一次记录后暂停使用的时长，0表示可用
+		wantDisabled time.Duration
+		wantFailures int
+	}{
+		{"below threshold", []error{failure, failure}, 0, 2},
+		{"threshold pauses", []error{failure, failure, failure}, botCooldown, 3},
+		{"cooldown doubles", []error{failure, failure, failure, failure}, 2 * botCooldown, 4},
+		{"success resets", []error{failure, failure, nil, failure}, 0, 1},
+		{"rate limited", []error{&TelegramAPIError{Code: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}}, 5 * time.Second, 0},
+		{"unauthorized", []error{&TelegramAPIError{Code: http.StatusUnauthorized}}, botMaxCooldown, 1},
+		{"kicked from chat", []error{&TelegramAPIError{Code: http.StatusForbidden}}, botMaxCooldown, 1},
+		{"chat not found", []error{failure, failure, &TelegramAPIError{Code: http.StatusBadRequest, Description: "Bad Request: chat not found"}}, botCooldown, 3},
+		{"bad request ignored", []error{failure, failure, &TelegramAPIError{Code: http.StatusBadRequest, Description: "Bad Request: PHOTO_INVALID_DIMENSIONS"}}, 0, 2},
+		{"canceled ignored", []error{failure, failure, context.Canceled}, 0, 2},
+		{"timeout ignored", []error{failure, failure, fmt.Errorf("upload: %w", context.DeadlineExceeded)}, 0, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &testClock{t: time.Unix(1700000000, 0)}
+			bot := newTestBots(t, 1, clock)[0]
+			for _, err := range tc.errs {
+				bot.record(err)
+			}
+
+			stats := bot.stats()
+			if stats.Failures != tc.wantFailures {
+				t.Fatalf("failures %d, want %d", stats.Failures, tc.wantFailures)
+			}
+			if tc.wantDisabled == 0 {
+				if !stats.Healthy {
+					t.Fatalf("bot disabled until %v", stats.DisabledUntil)
+				}
+				return
+			}
+			if stats.Healthy || !stats.DisabledUntil.Equal(clock.t.Add(tc.wantDisabled)) {
+				t.Fatalf("disabled until %v, want %s later", stats.DisabledUntil, tc.wantDisabled)
+			}
+
+			// 暂停结束后恢复可用
+			clock.t = clock.t.Add(tc.wantDisabled)
+			if !bot.healthy() {
+				t.Fatal("bot still disabled after cooldown")
+			}
+		})
+	}
+}
+
+func TestBotCooldownFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{botFailureThreshold, 30 * time.Second},
+		{botFailureThreshold + 1, time.Minute},
+		{botFailureThreshold + 4, 8 * time.Minute},
+		{botFailureThreshold + 5, botMaxCooldown},
+		{botFailureThreshold + 40, botMaxCooldown},
+		{1 << 20, botMaxCooldown},
+	}
+	for _, tc := range tests {
+		if got := botCooldownFor(tc.failures); got != tc.want {
+			t.Errorf("botCooldownFor(%d) = %s, want %s", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestNewTelegramBotPool(t *testing.T) {
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	bots := newTestBots(t, 2, clock)
+	tests := []struct {
+		name     string
+		bots     []*TelegramBot
+		strategy string
+		wantErr  bool
+	}{
+		{"default strategy", bots, "", false},
+		{"least loaded", bots, BotSelectLeastLoaded, false},
+		{"unknown strategy", bots, "random", true},
+		{"no bots", nil, "", true},
+		{"duplicate bot", []*TelegramBot{bots[0], bots[1], bots[0]}, "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pool, err := NewTelegramBotPool(tc.bots, tc.strategy)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("NewTelegramBotPool error = %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// 未记录机器人的文件使用主机器人下载
+			for id, want := range map[string]string{"": "1", "1": "1", "2": "2"} {
+				if bot, err := pool.Get(id); err != nil || bot.ID != want {
+					t.Fatalf("Get(%q) = %v, %v, want bot %s", id, bot, err, want)
+				}
+			}
+			if _, err := pool.Get("3"); err == nil {
+				t.Fatal("Get of an unknown bot should fail")
+			}
+		})
+	}
+}
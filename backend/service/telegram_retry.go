@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// telegramRetryBaseDelay 首次重试前的等待时长，之后每次翻倍
+	telegramRetryBaseDelay = 500 * time.Millisecond
+	// telegramRetryMaxDelay 两次重试之间的最长等待时长（不含retry_after）
+	telegramRetryMaxDelay = 10 * time.Second
+)
+
+var (
+	telegramClientOnce sync.Once
+	telegramClient     *http.Client
+	telegramClientErr  error
+)
+
+// telegramHTTPClient 访问Telegram使用的HTTP客户端，使用proxy.telegram或全局代理配置
+// 不设置整体超时，避免大文件下载被中断，仅限制建立连接和等待响应头的时间
+func telegramHTTPClient() (*http.Client, error) {
+	telegramClientOnce.Do(func() {
+		timeout := viper.GetDuration("telegram.timeout")
+		if timeout <= 0 {
+			timeout = 60 * time.Second
+		}
+
+		transport, err := NewProxyTransport(ProxyTargetTelegram)
+		if err != nil {
+			telegramClientErr = err
+			return
+		}
+		transport.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = 10 * time.Second
+		transport.ResponseHeaderTimeout = timeout
+
+		telegramClient = &http.Client{Transport: transport}
+	})
+	return telegramClient, telegramClientErr
+}
+
+// retry 执行请求，遇到网络错误、5xx和429时按指数退避重试
+// 429响应按Telegram返回的retry_after等待，等待时间超过上限时直接返回错误
+func (c *TelegramClient) retry(ctx context.Context, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		delay, ok := telegramRetryDelay(err, attempt, c.cfg.MaxRetryAfter)
+		if !ok || attempt >= c.cfg.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// telegramRetryDelay 判断错误是否可以重试，并计算重试前的等待时长
+func telegramRetryDelay(err error, attempt int, maxRetryAfter time.Duration) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	var apiErr *TelegramAPIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusTooManyRequests:
+			if apiErr.RetryAfter > maxRetryAfter {
+				return 0, false
+			}
+			if apiErr.RetryAfter > 0 {
+				return apiErr.RetryAfter, true
+			}
+		case apiErr.Code >= 500:
+		default:
+			return 0, false
+		}
+	} else {
+		// 只重试网络错误，响应解析失败等错误重试也不会成功
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) {
+			return 0, false
+		}
+	}
+
+	delay := telegramRetryBaseDelay << attempt
+	if delay <= 0 || delay > telegramRetryMaxDelay {
+		delay = telegramRetryMaxDelay
+	}
+	// 加入随机抖动，避免并发请求同时重试
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	return delay, true
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/spf13/viper"
+	"golang.org/x/image/draw"
+)
+
+// 缩放方式
+const (
+	// FitContain 等比缩放到目标尺寸以内
+	FitContain = "contain"
+	// FitCover 等比缩放到覆盖目标尺寸后居中裁剪
+	FitCover = "cover"
+	// FitFill 拉伸到目标尺寸
+	FitFill = "fill"
+)
+
+// 输出格式
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+const (
+	// maxTransformSide 输出图片的最大边长
+	maxTransformSide = 4096
+	// maxSourcePixels 允许处理的原图最大像素数，防止解码超大图片耗尽内存
+	maxSourcePixels = 50_000_000
+	// defaultJPEGQuality 默认JPEG质量
+	defaultJPEGQuality = 85
+)
+
+// TransformOptions 图片处理参数，Quality只对JPEG输出有效，为0时使用默认质量
+type TransformOptions struct {
+	Width   int
+	Height  int
+	Fit     string
+	Crop    image.Rectangle
+	Quality int
+	Format  string
+}
+
+// Validate 检查参数是否合法
+func (o TransformOptions) Validate() error {
+	if o.Width < 0 || o.Height < 0 || o.Width > maxTransformSide || o.Height > maxTransformSide {
+		return fmt.Errorf("宽高必须在0到%d之间", maxTransformSide)
+	}
+	switch o.Fit {
+	case "", FitContain, FitCover, FitFill:
+	default:
+		return fmt.Errorf("fit只能是contain、cover或fill")
+	}
+	switch o.Format {
+	case "", FormatJPEG, FormatPNG, FormatWebP:
+	default:
+		return fmt.Errorf("format只能是jpeg、png或webp")
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality必须在1到100之间")
+	}
+	if o.Quality != 0 && (o.Format == FormatPNG || o.Format == FormatWebP) {
+		return fmt.Errorf("quality只适用于JPEG输出")
+	}
+	if !o.Crop.Empty() && (o.Crop.Min.X < 0 || o.Crop.Min.Y < 0) {
+		return fmt.Errorf("裁剪区域不能为负数")
+	}
+	return nil
+}
+
+// CacheKey 生成用于缓存处理结果的参数描述
+func (o TransformOptions) CacheKey() string {
+	return fmt.Sprintf("w%d_h%d_f%s_c%d.%d.%d.%d_q%d_%s",
+		o.Width, o.Height, o.Fit,
+		o.Crop.Min.X, o.Crop.Min.Y, o.Crop.Dx(), o.Crop.Dy(),
+		o.Quality, o.Format)
+}
+
+// ContentTypeForFormat 输出格式对应的Content-Type
+func ContentTypeForFormat(format string) string {
+	switch format {
+	case FormatPNG:
+		return "image/png"
+	case FormatWebP:
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+var (
+	transformSlotsOnce sync.Once
+	transformSlots     chan struct{}
+)
+
+// AcquireTransformSlot 占用一个图片处理名额，同时处理的图片数不超过配置的image.transform_concurrency
+// 名额已满时等待，ctx取消时返回错误；处理完成后调用返回的函数释放名额
+func AcquireTransformSlot(ctx context.Context) (func(), error) {
+	transformSlotsOnce.Do(func() {
+		n := viper.GetInt("image.transform_concurrency")
+		if n <= 0 {
+			n = 4
+		}
+		transformSlots = make(chan struct{}, n)
+	})
+
+	select {
+	case transformSlots <- struct{}{}:
+		return func() { <-transformSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CanTransform 是否可以解码该类型的文件，生成缩略图和处理后的图片；类型未知的旧文件尝试处理
+func CanTransform(mimeType string) bool {
+	if mimeType == "" {
+		return true
+	}
+	for _, format := range imageFormats {
+		if format.mime == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// TransformImage 按参数裁剪、缩放并重新编码图片，返回编码后的内容和输出格式
+// 先读取图片头检查尺寸，超过maxSourcePixels的图片不解码
+func TransformImage(r io.Reader, opts TransformOptions) ([]byte, string, error) {
+	var head bytes.Buffer
+	cfg, srcFormat, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		return nil, "", fmt.Errorf("不支持的图片格式: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, "", fmt.Errorf("图片尺寸过大: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	// 输出格式跟随原图时同样检查quality是否适用
+	format := opts.Format
+	if format == "" {
+		format = srcFormat
+	}
+	if opts.Quality != 0 && (format == FormatPNG || format == "gif" || format == FormatWebP) {
+		return nil, "", fmt.Errorf("quality只适用于JPEG输出，原图格式为%s", srcFormat)
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return nil, "", fmt.Errorf("解码图片失败: %w", err)
+	}
+
+	if !opts.Crop.Empty() {
+		crop := opts.Crop.Add(src.Bounds().Min).Intersect(src.Bounds())
+		if crop.Empty() {
+			return nil, "", fmt.Errorf("裁剪区域超出图片范围")
+		}
+		src = subImage(src, crop)
+	}
+
+	dst := resizeImage(src, opts.Width, opts.Height, opts.Fit)
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatPNG, "gif":
+		format = FormatPNG
+		err = png.Encode(&buf, dst)
+	case FormatWebP:
+		err = nativewebp.Encode(&buf, dst, nil)
+	default:
+		format = FormatJPEG
+		quality := opts.Quality
+		if quality == 0 {
+			quality = defaultJPEGQuality
+		}
+		err = jpeg.Encode(&buf, flatten(dst), &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("编码图片失败: %w", err)
+	}
+
+	return buf.Bytes(), format, nil
+}
+
+// resizeImage 按缩放方式调整图片尺寸，宽高只指定一个时按原图比例计算另一个
+func resizeImage(src image.Image, width, height int, fit string) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	if width == 0 && height == 0 {
+		return src
+	}
+	if width == 0 {
+		width = max(1, sw*height/sh)
+	}
+	if height == 0 {
+		height = max(1, sh*width/sw)
+	}
+
+	switch fit {
+	case FitFill:
+		return scale(src, b, width, height)
+	case FitCover:
+		// 按目标宽高比从原图中居中截取，再缩放到目标尺寸
+		cw, ch := sw, sw*height/width
+		if ch > sh {
+			cw, ch = sh*width/height, sh
+		}
+		x := b.Min.X + (sw-cw)/2
+		y := b.Min.Y + (sh-ch)/2
+		return scale(src, image.Rect(x, y, x+cw, y+ch), width, height)
+	default:
+		w, h := width, sh*width/sw
+		if h > height {
+			w, h = sw*height/sh, height
+		}
+		return scale(src, b, max(1, w), max(1, h))
+	}
+}
+
+// scale 将原图的指定区域缩放到目标尺寸
+func scale(src image.Image, sr image.Rectangle, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, sr, draw.Src, nil)
+	return dst
+}
+
+// subImage 截取图片的指定区域
+func subImage(src image.Image, r image.Rectangle) image.Image {
+	if s, ok := src.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, r.Min, draw.Src)
+	return dst
+}
+
+// flatten 将透明区域填充为白色，JPEG不支持透明通道
+func flatten(src image.Image) image.Image {
+	if o, ok := src.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return src
+	}
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// ErrTusUploadNotFound 断点续传上传不存在或已过期
+	ErrTusUploadNotFound = errors.New("上传不存在或已过期")
+	// ErrTusOffsetMismatch 客户端提供的偏移量与已接收的数据量不一致
+	ErrTusOffsetMismatch = errors.New("上传偏移量不匹配")
+)
+
+// TusUpload 断点续传上传的状态
+type TusUpload struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id"`
+	UploadIP  string            `json:"upload_ip"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"-"`
+	Metadata  map[string]string `json:"metadata"`
+	RawMeta   string            `json:"raw_metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Complete 是否已接收全部数据
+func (u *TusUpload) Complete() bool {
+	return u.Offset >= u.Length
+}
+
+// TusStore 断点续传上传的本地存储
+// 每个上传保存为数据文件和记录状态的info文件，已接收的数据量即数据文件的大小
+type TusStore struct {
+	dir    string
+	expire time.Duration
+	locks  *keyedMutex
+}
+
+var tusStore *TusStore
+
+// InitTusStore 根据配置初始化断点续传存储，并定期清理过期的上传
+func InitTusStore() error {
+	dir := viper.GetString("upload.tus_dir")
+	if dir == "" {
+		dir = "./spool/tus"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建断点续传目录失败: %w", err)
+	}
+
+	expire := viper.GetDuration("upload.tus_expire")
+	if expire <= 0 {
+		expire = 24 * time.Hour
+	}
+
+	s := &TusStore{
+		dir:    dir,
+		expire: expire,
+		locks:  &keyedMutex{locks: make(map[string]*keyedLock)},
+	}
+	go func() {
+		for {
+			s.cleanup()
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	tusStore = s
+	return nil
+}
+
+// TusUploads 获取断点续传存储
+func TusUploads() (*TusStore, error) {
+	if tusStore == nil {
+		return nil, fmt.Errorf("断点续传未启用")
+	}
+	return tusStore, nil
+}
+
+// Create 创建上传，rawMetadata为Upload-Metadata请求头
+func (s *TusStore) Create(userID, uploadIP string, length int64, rawMetadata string) (*TusUpload, error) {
+	metadata, err := ParseTusMetadata(rawMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := &TusUpload{
+		ID:        hex.EncodeToString(buf),
+		UserID:    userID,
+		UploadIP:  uploadIP,
+		Length:    length,
+		Metadata:  metadata,
+		RawMeta:   rawMetadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.expire),
+	}
+
+	f, err := os.OpenFile(s.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	if err := s.writeInfo(upload); err != nil {
+		os.Remove(s.dataPath(upload.ID))
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Get 获取上传状态
+func (s *TusStore) Get(id string) (*TusUpload, error) {
+	if !validTusID(id) {
+		return nil, ErrTusUploadNotFound
+	}
+
+	data, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrTusUploadNotFound
+		}
+		return nil, err
+	}
+
+	var upload TusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrTusUploadNotFound
+	}
+
+	fi, err := os.Stat(s.dataPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrTusUploadNotFound
+		}
+		return nil, err
+	}
+	upload.Offset = fi.Size()
+
+	return &upload, nil
+}
+
+// Append 从offset处追加数据，最多写入到上传的总长度，返回追加后的状态
+// 读取请求体中断时已写入的数据会保留，客户端可以从新的偏移量继续上传
+// 调用前需通过Lock对上传加锁
+func (s *TusStore) Append(id string, offset int64, r io.Reader) (*TusUpload, error) {
+	upload, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrTusOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r, upload.Length-upload.Offset))
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	upload.Offset += n
+
+	return upload, copyErr
+}
+
+// Open 打开上传的数据文件，关闭时不删除
+func (s *TusStore) Open(id string) (*UploadFile, error) {
+	if !validTusID(id) {
+		return nil, ErrTusUploadNotFound
+	}
+	return OpenUploadFile(s.dataPath(id))
+}
+
+// Lock 对上传加锁，避免同一上传被并发追加或完成，返回解锁函数
+func (s *TusStore) Lock(id string) func() {
+	return s.locks.lock(id)
+}
+
+// Delete 删除上传及其数据
+func (s *TusStore) Delete(id string) error {
+	if !validTusID(id) {
+		return ErrTusUploadNotFound
+	}
+	os.Remove(s.dataPath(id))
+	if err := os.Remove(s.infoPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// cleanup 删除过期的上传
+func (s *TusStore) cleanup() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("清理断点续传目录失败: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok || !validTusID(id) {
+			continue
+		}
+		if _, err := s.Get(id); errors.Is(err, ErrTusUploadNotFound) {
+			s.Delete(id)
+		}
+	}
+}
+
+func (s *TusStore) writeInfo(upload *TusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.infoPath(upload.ID), data, 0644)
+}
+
+func (s *TusStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *TusStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// validTusID 上传ID为32位十六进制字符串，防止路径穿越
+func validTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// ParseTusMetadata 解析Upload-Metadata请求头，格式为逗号分隔的“键 base64值”
+func ParseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("Upload-Metadata格式错误")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata格式错误: %s", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/telegram-photo/model"
+)
+
+// UploadOptions 保存上传文件的参数
+type UploadOptions struct {
+	UserID      string
+	UploadIP    string
+	Filename    string
+	ContentType string
+	// Mode 上传模式，为空时使用配置的默认模式
+	Mode string
+	// OnProgress 上传到存储后端时报告已读取的字节数
+	OnProgress func(read int64)
+}
+
+// UploadResult 保存上传文件的结果
+type UploadResult struct {
+	File       *model.File
+	Image      *model.Image
+	MD5Hash    string
+	SHA256Hash string
+	// Existing 已存在相同内容的文件，未重复上传到存储后端
+	Existing bool
+	// Owned 用户已拥有该图片，未创建新的图片记录
+	Owned bool
+	// Metadata 新建图片记录的元数据，Owned为true时为空
+	Metadata *model.ImageMetadata
+}
+
+// StoreUpload 保存上传的文件
+// 文件内容不是允许的图片格式时返回ErrInvalidImage
+// 按upload.exif_policy处理EXIF后按SHA-256去重，新文件上传到默认存储后端并创建文件记录，再为用户创建图片记录
+// document模式的上传只复用保留原始内容的文件，内容相同的photo模式文件不满足要求时重新上传
+// upload.passthrough_formats中的视频和RAW等格式原样以document模式保存
+func StoreUpload(ctx context.Context, upload *UploadFile, opts UploadOptions) (*UploadResult, error) {
+	// 根据文件内容确定类型和扩展名，不信任客户端提供的信息
+	info, err := upload.DetectImage()
+	if err != nil {
+		return nil, err
+	}
+	originalFilename := opts.Filename
+	opts.ContentType = info.MimeType
+	opts.Filename = ImageFilename(opts.Filename, info)
+
+	// 删除图片数据之后附加的内容，如动态照片中的视频
+	if info.DataEnd > 0 {
+		truncated, err := upload.truncate(info.DataEnd)
+		if err != nil {
+			return nil, err
+		}
+		defer truncated.Close()
+		upload = truncated
+		info, _ = upload.DetectImage()
+	}
+	// 视频和RAW等格式以图片形式发送会被Telegram拒绝或压缩，始终作为文件上传
+	if info.Passthrough {
+		opts.Mode = UploadModeDocument
+	}
+
+	// 按配置的策略处理元数据，处理后的内容作为去重和保存的依据
+	policy := ExifPolicy()
+	exif, processed, err := applyExifPolicy(upload, info, policy)
+	if err != nil {
+		// 无法确认元数据已被删除时拒绝上传
+		if policy != ExifKeep {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		exif = &ExifData{}
+	}
+	if processed != nil {
+		defer processed.Close()
+		upload = processed
+	}
+
+	result := &UploadResult{MD5Hash: upload.MD5Hash, SHA256Hash: upload.SHA256Hash}
+
+	// 相同内容的并发上传依次执行，后执行的直接复用先上传的文件
+	unlock := uploadLocks.lock(upload.SHA256Hash)
+	defer unlock()
+
+	// 检查是否已存在相同内容的文件，MD5可以被构造碰撞，只按SHA-256判断
+	modes := reusableUploadModes(opts.Mode)
+	existingFile, err := model.GetFileBySHA256Hash(upload.SHA256Hash, modes...)
+	if err != nil {
+		existingFile, err = findLegacyFile(ctx, upload)
+	}
+	if err != nil {
+		file, err := putFile(ctx, upload, info, originalFilename, opts)
+		switch {
+		case errors.Is(err, model.ErrDuplicateFile):
+			// 其他实例同时上传了相同内容，复用先保存的文件
+			existingFile, err = model.GetFileBySHA256Hash(upload.SHA256Hash, modes...)
+			if err != nil {
+				return nil, fmt.Errorf("保存文件记录失败: %w", err)
+			}
+		case err != nil:
+			return nil, err
+		default:
+			result.File = file
+		}
+	}
+	if existingFile != nil {
+		result.File = existingFile
+		result.Existing = true
+
+		// 补充旧文件记录缺少的类型和感知哈希
+		if existingFile.MimeType == "" {
+			if err := model.UpdateFile(existingFile.ID, map[string]interface{}{"mime_type": info.MimeType}); err == nil {
+				existingFile.MimeType = info.MimeType
+			}
+		}
+		if existingFile.PHash == "" && !info.Passthrough {
+			schedulePerceptualHash(existingFile.ID, upload)
+		}
+
+		// 用户已绑定该文件，直接返回现有记录
+		existingImage, err := model.GetImageByFileIDAndUserID(existingFile.ID, opts.UserID)
+		if err == nil && existingImage != nil {
+			result.Image = existingImage
+			result.Owned = true
+			return result, nil
+		}
+	}
+
+	// 创建图片记录，关联用户和文件
+	image := &model.Image{
+		FileID:   result.File.ID,
+		UserID:   opts.UserID,
+		UploadIP: opts.UploadIP,
+	}
+
+	// 确保uploadIP不为空
+	if image.UploadIP == "" {
+		image.UploadIP = "unknown"
+	}
+
+	metadata := &model.ImageMetadata{
+		CameraMake:  truncate(exif.Make, 100),
+		CameraModel: truncate(exif.Model, 100),
+		TakenAt:     exif.TakenAt,
+		Orientation: exif.Orientation,
+		Width:       info.Width,
+		Height:      info.Height,
+		HasGPS:      exif.HasGPS,
+		ExifPolicy:  policy,
+	}
+
+	if err := model.CreateImageWithMetadata(image, metadata); err != nil {
+		return nil, fmt.Errorf("保存图片记录失败: %w", err)
+	}
+	result.Image = image
+	result.Metadata = metadata
+
+	return result, nil
+}
+
+// reusableUploadModes 去重时可以复用的文件上传模式，为空时不限制
+// document模式要求保留原始内容，不复用以图片形式发送、已被Telegram压缩的文件
+func reusableUploadModes(mode string) []string {
+	if mode == "" {
+		mode = DefaultUploadMode()
+	}
+	if mode == UploadModeDocument {
+		return []string{UploadModeDocument}
+	}
+	return nil
+}
+
+// errLegacyMismatch MD5相同的旧文件内容与上传的内容不一致
+var errLegacyMismatch = errors.New("旧文件内容与上传的内容不一致")
+
+// findLegacyFile 按MD5查找升级前上传、尚未补全SHA-256的文件，下载校验内容后填写SHA-256
+// MD5可以被构造碰撞，只有下载得到的SHA-256与上传的内容一致时才复用
+// 以photo模式上传的旧文件已被Telegram压缩，无法校验，按新文件上传
+func findLegacyFile(ctx context.Context, upload *UploadFile) (*model.File, error) {
+	file, err := model.GetFileByMD5HashWithoutSHA256(upload.MD5Hash, UploadModeDocument)
+	if err != nil {
+		return nil, err
+	}
+	if file.Size > 0 && file.Size != upload.Size {
+		return nil, errLegacyMismatch
+	}
+
+	body, err := OpenStoredFile(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return nil, err
+	}
+
+	// 填写下载得到的SHA-256，不一致时之后也不再按MD5匹配该文件
+	if err := model.SetFileSHA256(file, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return nil, err
+	}
+	if file.SHA256Hash != upload.SHA256Hash {
+		return nil, errLegacyMismatch
+	}
+	return file, nil
+}
+
+// putFile 上传文件到默认存储后端，并创建文件记录及其分块和尺寸版本
+// originalFilename为客户端提供的文件名，记录在文件上用于展示
+func putFile(ctx context.Context, upload *UploadFile, info *ImageInfo, originalFilename string, opts UploadOptions) (*model.File, error) {
+	r := upload.Reader()
+	if opts.OnProgress != nil {
+		r = io.NewSectionReader(&progressReaderAt{r: r, fn: opts.OnProgress}, 0, upload.Size)
+	}
+
+	st := DefaultStorage()
+	result, err := st.Put(ctx, r, PutOptions{
+		Filename:    opts.Filename,
+		Size:        upload.Size,
+		ContentType: opts.ContentType,
+		Mode:        opts.Mode,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("上传图片失败: %w", err)
+	}
+
+	// 创建文件记录
+	file := &model.File{
+		TelegramFileID: result.Key,
+		MD5Hash:        upload.MD5Hash,
+		SHA256Hash:     upload.SHA256Hash,
+		Storage:        st.Name(),
+		UploadMode:     result.Mode,
+		TelegramBot:    result.Bot,
+		MimeType:       opts.ContentType,
+		Width:          info.Width,
+		Height:         info.Height,
+		Size:           upload.Size,
+	}
+	if name := filepath.Base(originalFilename); originalFilename != "" && name != "." && name != string(filepath.Separator) {
+		file.OriginalFilename = truncate(name, 255)
+	}
+
+	chunks := make([]model.FileChunk, 0, len(result.Chunks))
+	for _, chunk := range result.Chunks {
+		chunks = append(chunks, model.FileChunk{TelegramFileID: chunk.Key, Size: chunk.Size})
+	}
+
+	variants := make([]model.FileVariant, 0, len(result.Variants))
+	for _, variant := range result.Variants {
+		// 以图片形式发送时原图为Telegram压缩后的最大尺寸版本
+		if variant.Key == result.Key {
+			file.MimeType = "image/jpeg"
+			file.Width = variant.Width
+			file.Height = variant.Height
+			if variant.Size > 0 {
+				file.Size = variant.Size
+			}
+		}
+		variants = append(variants, model.FileVariant{
+			TelegramFileID: variant.Key,
+			Width:          variant.Width,
+			Height:         variant.Height,
+			FileSize:       variant.Size,
+		})
+	}
+
+	if err := model.CreateFileWithParts(file, chunks, variants); err != nil {
+		if errors.Is(err, model.ErrDuplicateFile) {
+			deleteStoredObjects(st, result)
+		}
+		return nil, fmt.Errorf("保存文件记录失败: %w", err)
+	}
+
+	// 如果ID为0，说明创建过程中出现了问题
+	if file.ID == 0 {
+		return nil, fmt.Errorf("创建文件记录后未获取到有效ID")
+	}
+
+	// 感知哈希需要解码整张图片，在后台计算，无法解码的图片不填写
+	if !info.Passthrough {
+		schedulePerceptualHash(file.ID, upload)
+	}
+
+	return file, nil
+}
+
+// deleteStoredObjects 删除已上传但未创建文件记录的对象，Telegram不支持删除，失败时只记录日志
+func deleteStoredObjects(st Storage, result *PutResult) {
+	keys := []string{result.Key}
+	for _, chunk := range result.Chunks {
+		keys = append(keys, chunk.Key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := st.Delete(ctx, key); errors.Is(err, ErrNotSupported) {
+			return
+		} else if err != nil {
+			log.Printf("删除重复上传的文件失败 - key: %s, 错误: %v", key, err)
+		}
+	}
+}
+
+// keyedMutex 按键加锁，不同键之间互不阻塞
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// uploadLocks 按SHA-256对上传加锁
+var uploadLocks = &keyedMutex{locks: make(map[string]*keyedLock)}
+
+// lock 对键加锁，返回解锁函数
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telegram-photo/model"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// benchDB 不访问数据库的database/sql驱动，查询返回空结果，插入返回递增的ID
+type benchDB struct {
+	lastID atomic.Int64
+}
+
+type benchConn struct{ db *benchDB }
+type benchStmt struct{ db *benchDB }
+type benchTx struct{}
+type benchResult struct{ id int64 }
+type benchRows struct{}
+
+func (d *benchDB) Open(string) (driver.Conn, error) { return &benchConn{db: d}, nil }
+
+func (c *benchConn) Prepare(string) (driver.Stmt, error) { return &benchStmt{db: c.db}, nil }
+func (c *benchConn) Close() error                        { return nil }
+func (c *benchConn) Begin() (driver.Tx, error)           { return benchTx{}, nil }
+
+func (s *benchStmt) Close() error  { return nil }
+func (s *benchStmt) NumInput() int { return -1 }
+func (s *benchStmt) Exec([]driver.Value) (driver.Result, error) {
+	return benchResult{id: s.db.lastID.Add(1)}, nil
+}
+func (s *benchStmt) Query([]driver.Value) (driver.Rows, error) { return benchRows{}, nil }
+
+func (benchTx) Commit() error   { return nil }
+func (benchTx) Rollback() error { return nil }
+
+func (r benchResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r benchResult) RowsAffected() (int64, error) { return 1, nil }
+
+func (benchRows) Columns() []string         { return nil }
+func (benchRows) Close() error              { return nil }
+func (benchRows) Next([]driver.Value) error { return io.EOF }
+
+var registerBenchDB sync.Once
+
+// useBenchDB 将model.DB替换为不访问数据库的连接
+func useBenchDB(b *testing.B) {
+	b.Helper()
+	registerBenchDB.Do(func() { sql.Register("benchdb", &benchDB{}) })
+
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DriverName:                "benchdb",
+		DSN:                       "bench",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{Logger: logger.Discard, DisableAutomaticPing: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	saved := model.DB
+	model.DB = db
+	b.Cleanup(func() { model.DB = saved })
+}
+
+// fakeUploadServer 模拟Bot API的sendPhoto和sendDocument，流式丢弃上传的文件
+func fakeUploadServer(b *testing.B) *httptest.Server {
+	var nextID atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var size int64
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			n, _ := io.Copy(io.Discard, part)
+			size += n
+		}
+
+		id := fmt.Sprintf("file-%d", nextID.Add(1))
+		var result []byte
+		if strings.HasSuffix(r.URL.Path, "/sendPhoto") {
+			result, _ = json.Marshal(Message{Photo: []PhotoSize{{FileID: id, Width: 1280, Height: 1280, FileSize: int(size)}}})
+		} else {
+			result, _ = json.Marshal(Message{Document: &Document{FileID: id, FileSize: int(size)}})
+		}
+		json.NewEncoder(w).Encode(TelegramResponse{Ok: true, Result: result})
+	}))
+	b.Cleanup(srv.Close)
+	return srv
+}
+
+// writeNoisePNG 写出内容随机、无法压缩的PNG图片，返回文件路径
+func writeNoisePNG(b *testing.B, dir string, seed int64, side int) string {
+	b.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, side, side))
+	rng := rand.New(rand.NewSource(seed))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("noise-%d.png", seed))
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if err := (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(f, img); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// peakHeap 定期采样HeapInuse，记录开始以来的峰值
+type peakHeap struct {
+	peak atomic.Uint64
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startPeakHeap(interval time.Duration) *peakHeap {
+	p := &peakHeap{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > p.peak.Load() {
+				p.peak.Store(ms.HeapInuse)
+			}
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return p
+}
+
+func (p *peakHeap) Stop() uint64 {
+	close(p.stop)
+	<-p.done
+	return p.peak.Load()
+}
+
+// BenchmarkStoreUploadConcurrent20MB 并发保存多个20MB的图片到模拟的Telegram
+// peak-heap-MB为上传过程中HeapInuse的峰值，应与文件大小无关，只随并发数和同时解码的图片数增长
+func BenchmarkStoreUploadConcurrent20MB(b *testing.B) {
+	const concurrency = 4
+
+	useBenchDB(b)
+	srv := fakeUploadServer(b)
+	useTestTelegramBot(b, srv.URL)
+
+	savedStorage := defaultStorage
+	defaultStorage = NewTelegramStorage()
+	b.Cleanup(func() { defaultStorage = savedStorage })
+
+	// 2290x2290的RGBA像素不压缩时约20MB，每个并发上传使用不同内容，避免按SHA-256去重后串行执行
+	dir := b.TempDir()
+	paths := make([]string, concurrency)
+	var size int64
+	for i := range paths {
+		paths[i] = writeNoisePNG(b, dir, int64(i+1), 2290)
+		fi, err := os.Stat(paths[i])
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = fi.Size()
+	}
+
+	b.SetBytes(size * concurrency)
+	b.ReportAllocs()
+	runtime.GC()
+	sampler := startPeakHeap(5 * time.Millisecond)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		errs := make(chan error, concurrency)
+		for _, path := range paths {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				upload, err := OpenUploadFile(path)
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer upload.Close()
+
+				_, err = StoreUpload(context.Background(), upload, UploadOptions{
+					UserID:   "bench",
+					UploadIP: "127.0.0.1",
+					Filename: filepath.Base(path),
+					Mode:     UploadModeDocument,
+				})
+				if err != nil {
+					errs <- err
+				}
+			}(path)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			b.Fatal(err)
+		}
+		// 感知哈希在后台计算，计入每次上传的耗时和内存峰值
+		waitPerceptualHashes()
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(sampler.Stop())/(1024*1024), "peak-heap-MB")
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+)
+
+// ErrFileTooLarge 上传的文件超过大小限制
+var ErrFileTooLarge = errors.New("文件大小超过限制")
+
+// UploadFile 保存在本地磁盘上的上传文件
+// 上传内容先写入磁盘并计算哈希，之后从磁盘流式读取，内存占用与文件大小无关
+type UploadFile struct {
+	f          *os.File
+	Size       int64
+	MD5Hash    string
+	SHA256Hash string
+	// temp 是否为SpoolUpload创建的临时文件，关闭时删除
+	temp bool
+
+	// holds 后台任务持有的引用数，Close在引用全部释放后才关闭文件
+	mu     sync.Mutex
+	holds  int
+	closed bool
+
+	info    *ImageInfo
+	infoErr error
+}
+
+// SpoolUpload 将上传内容写入临时文件，同时计算MD5和SHA-256
+// maxSize大于0时，内容超过该大小返回ErrFileTooLarge
+func SpoolUpload(r io.Reader, maxSize int64) (*UploadFile, error) {
+	f, err := os.CreateTemp(viper.GetString("upload.temp_dir"), "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	u := &UploadFile{f: f, temp: true}
+
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, md5Hash, sha256Hash), r)
+	if err != nil {
+		u.Close()
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	if maxSize > 0 && size > maxSize {
+		u.Close()
+		return nil, ErrFileTooLarge
+	}
+
+	u.Size = size
+	u.MD5Hash = fmt.Sprintf("%x", md5Hash.Sum(nil))
+	u.SHA256Hash = fmt.Sprintf("%x", sha256Hash.Sum(nil))
+	return u, nil
+}
+
+// OpenUploadFile 打开已保存在磁盘上的文件并计算哈希，关闭时不删除文件
+func OpenUploadFile(path string) (*UploadFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &UploadFile{
+		f:          f,
+		Size:       size,
+		MD5Hash:    fmt.Sprintf("%x", md5Hash.Sum(nil)),
+		SHA256Hash: fmt.Sprintf("%x", sha256Hash.Sum(nil)),
+	}, nil
+}
+
+// Reader 从头读取文件内容，返回的读取器支持Seek和ReadAt，上传失败重试时可以重新读取
+func (u *UploadFile) Reader() *io.SectionReader {
+	return io.NewSectionReader(u.f, 0, u.Size)
+}
+
+// DetectImage 根据文件内容识别图片格式，结果会被缓存
+func (u *UploadFile) DetectImage() (*ImageInfo, error) {
+	if u.info == nil && u.infoErr == nil {
+		u.info, u.infoErr = DetectImage(u.f, u.Size)
+	}
+	return u.info, u.infoErr
+}
+
+// truncate 截取文件开头size字节写入新的临时文件，用于删除图片数据之后附加的内容，调用方负责关闭
+func (u *UploadFile) truncate(size int64) (*UploadFile, error) {
+	truncated, err := SpoolUpload(io.NewSectionReader(u.f, 0, size), 0)
+	if err != nil {
+		return nil, err
+	}
+	if u.info != nil {
+		info := *u.info
+		info.DataEnd = 0
+		truncated.info = &info
+	}
+	return truncated, nil
+}
+
+// Close 关闭文件，临时文件同时删除；后台任务仍在读取时延后到任务释放文件
+func (u *UploadFile) Close() error {
+	u.mu.Lock()
+	u.closed = true
+	holds := u.holds
+	u.mu.Unlock()
+	if holds > 0 {
+		return nil
+	}
+	return u.closeFile()
+}
+
+// hold 为后台任务保留文件，任务完成后调用release
+func (u *UploadFile) hold() {
+	u.mu.Lock()
+	u.holds++
+	u.mu.Unlock()
+}
+
+// release 释放hold保留的文件，已调用过Close时关闭文件
+func (u *UploadFile) release() {
+	u.mu.Lock()
+	u.holds--
+	last := u.holds == 0 && u.closed
+	u.mu.Unlock()
+	if last {
+		u.closeFile()
+	}
+}
+
+func (u *UploadFile) closeFile() error {
+	err := u.f.Close()
+	if u.temp {
+		os.Remove(u.f.Name())
+	}
+	return err
+}
+
+// progressReaderAt 报告已读取到的最大偏移量
+// 重试时会重新读取已读过的内容，按最大偏移量报告进度不会回退
+type progressReaderAt struct {
+	r    io.ReaderAt
+	read atomic.Int64
+	fn   func(read int64)
+}
+
+func (p *progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.r.ReadAt(b, off)
+	if end := off + int64(n); n > 0 {
+		for {
+			read := p.read.Load()
+			if end <= read {
+				break
+			}
+			if p.read.CompareAndSwap(read, end) {
+				p.fn(end)
+				break
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/telegram-photo/model"
+)
+
+const (
+	// uploadQueuePollInterval 没有新任务通知时检查到期任务的间隔
+	uploadQueuePollInterval = 2 * time.Second
+	// uploadJobRetryDelay 任务首次重试前的等待时长，之后每次翻倍
+	uploadJobRetryDelay = 10 * time.Second
+	// uploadJobMaxRetryDelay 任务重试前的最长等待时长
+	uploadJobMaxRetryDelay = 10 * time.Minute
+	// uploadJobLease 领取任务的租约时长，处理期间每隔三分之一租约续期一次
+	uploadJobLease = 2 * time.Minute
+)
+
+// ErrUploadQueueDisabled 上传任务队列未启动
+var ErrUploadQueueDisabled = errors.New("上传任务队列未启动")
+
+// UploadQueue 异步上传任务队列
+// 任务保存在数据库中，文件暂存在本地目录，由固定数量的后台任务依次上传到存储后端
+// 多个进程共用数据库时，各进程以owner标识持有所领取任务的租约；暂存目录默认只在本节点可见，
+// 任务记录暂存的节点，只由该节点的进程执行，upload.spool_shared开启时暂存目录为各节点共享的存储，任何节点都可以执行
+type UploadQueue struct {
+	dir         string
+	workers     int
+	maxAttempts int
+	owner       string
+	// host 当前节点，记录在新任务上
+	host string
+	// shared 暂存目录是否为各节点共享的存储
+	shared bool
+
+	wake     chan struct{}
+	progress sync.Map
+}
+
+var uploadQueue *UploadQueue
+
+// InitUploadQueue 根据配置初始化上传任务队列并启动后台任务
+func InitUploadQueue() error {
+	dir := viper.GetString("upload.spool_dir")
+	if dir == "" {
+		dir = "./spool"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建暂存目录失败: %w", err)
+	}
+
+	workers := viper.GetInt("upload.workers")
+	if workers <= 0 {
+		workers = 4
+	}
+
+	maxAttempts := viper.GetInt("upload.job_max_attempts")
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+
+	owner, err := uploadQueueOwner()
+	if err != nil {
+		return err
+	}
+
+	host := viper.GetString("upload.node_id")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	q := &UploadQueue{
+		dir:         dir,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		owner:       owner,
+		host:        truncate(host, 100),
+		shared:      viper.GetBool("upload.spool_shared"),
+		wake:        make(chan struct{}, 1),
+	}
+
+	// 租约已到期的任务重新执行，其他进程正在处理的任务不受影响
+	if err := q.reclaim(); err != nil {
+		return fmt.Errorf("恢复上传任务失败: %w", err)
+	}
+	go q.run()
+	go q.reclaimLoop()
+
+	uploadQueue = q
+	return nil
+}
+
+// UploadJobs 获取上传任务队列
+func UploadJobs() (*UploadQueue, error) {
+	if uploadQueue == nil {
+		return nil, ErrUploadQueueDisabled
+	}
+	return uploadQueue, nil
+}
+
+// Enqueue 暂存文件并创建上传任务
+func (q *UploadQueue) Enqueue(r io.Reader, opts UploadOptions) (*model.UploadJob, error) {
+	f, err := os.CreateTemp(q.dir, "job-*")
+	if err != nil {
+		return nil, fmt.Errorf("暂存文件失败: %w", err)
+	}
+	size, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("暂存文件失败: %w", err)
+	}
+
+	job := &model.UploadJob{
+		UserID:      opts.UserID,
+		UploadIP:    opts.UploadIP,
+		Filename:    opts.Filename,
+		ContentType: opts.ContentType,
+		Mode:        opts.Mode,
+		Size:        size,
+		SpoolPath:   f.Name(),
+		SpoolHost:   q.host,
+		Status:      model.UploadJobPending,
+		MaxAttempts: q.maxAttempts,
+		NextRunAt:   time.Now(),
+	}
+	if err := model.CreateUploadJob(job); err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("创建上传任务失败: %w", err)
+	}
+
+	q.notify()
+	return job, nil
+}
+
+// Progress 正在处理的任务已上传的字节数，任务不在处理中时返回false
+func (q *UploadQueue) Progress(id uint) (int64, bool) {
+	v, ok := q.progress.Load(id)
+	if !ok {
+		return 0, false
+	}
+	return v.(*atomic.Int64).Load(), true
+}
+
+// uploadQueueOwner 生成标识当前进程的租约持有者
+func uploadQueueOwner() (string, error) {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return truncate(fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b)), 100), nil
+}
+
+// reclaim 将租约已到期的任务恢复为待处理
+func (q *UploadQueue) reclaim() error {
+	n, err := model.ReclaimExpiredUploadJobs()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("恢复了%d个租约已到期的上传任务", n)
+		q.notify()
+	}
+	return nil
+}
+
+// reclaimLoop 定期恢复租约已到期的任务
+func (q *UploadQueue) reclaimLoop() {
+	ticker := time.NewTicker(uploadJobLease / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := q.reclaim(); err != nil {
+			log.Printf("恢复上传任务失败: %v", err)
+		}
+	}
+}
+
+// notify 通知后台任务有新任务
+func (q *UploadQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 领取到期的任务并交给空闲的后台任务处理
+func (q *UploadQueue) run() {
+	slots := make(chan struct{}, q.workers)
+	for {
+		slots <- struct{}{}
+
+		job, err := q.next()
+		if err != nil {
+			log.Printf("获取上传任务失败: %v", err)
+		}
+		if job == nil {
+			<-slots
+			select {
+			case <-q.wake:
+			case <-time.After(uploadQueuePollInterval):
+			}
+			continue
+		}
+
+		go func() {
+			defer func() { <-slots }()
+			q.process(job)
+		}()
+	}
+}
+
+// claimHost 领取任务时限定的暂存节点，暂存目录共享时不限定
+func (q *UploadQueue) claimHost() string {
+	if q.shared {
+		return ""
+	}
+	return q.host
+}
+
+// next 领取一个到期的任务，没有可执行的任务时返回nil
+func (q *UploadQueue) next() (*model.UploadJob, error) {
+	ids, err := model.GetDueUploadJobIDs(q.claimHost(), 10)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		ok, err := model.ClaimUploadJob(id, q.owner, q.claimHost(), uploadJobLease)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return model.GetUploadJobByID(id)
+		}
+	}
+	return nil, nil
+}
+
+// process 执行上传任务，处理期间定期续期租约，租约被其他进程领取时取消上传
+func (q *UploadQueue) process(job *model.UploadJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.keepLease(ctx, cancel, job.ID)
+
+	file, err := OpenUploadFile(job.SpoolPath)
+	if err != nil {
+		if os.IsNotExist(err) && !q.shared && job.SpoolHost != q.host {
+			// 未记录节点的旧任务，暂存文件可能在其他节点上，交还给其他节点执行
+			q.handOff(job, err)
+			return
+		}
+		// 暂存文件丢失时重试也无法成功
+		q.finish(job, fmt.Errorf("读取暂存文件失败: %w", err), false)
+		return
+	}
+
+	progress := &atomic.Int64{}
+	q.progress.Store(job.ID, progress)
+	defer q.progress.Delete(job.ID)
+
+	result, err := StoreUpload(ctx, file, UploadOptions{
+		UserID:      job.UserID,
+		UploadIP:    job.UploadIP,
+		Filename:    job.Filename,
+		ContentType: job.ContentType,
+		Mode:        job.Mode,
+		OnProgress:  progress.Store,
+	})
+	file.Close()
+	if err != nil {
+		// 文件内容不是允许的图片时重试也无法成功
+		q.finish(job, err, !errors.Is(err, ErrInvalidImage))
+		return
+	}
+
+	now := time.Now()
+	ok, err := model.ReleaseUploadJob(job.ID, q.owner, map[string]interface{}{
+		"status":           model.UploadJobSucceeded,
+		"error":            "",
+		"file_id":          result.File.ID,
+		"image_id":         result.Image.ID,
+		"telegram_file_id": result.File.TelegramFileID,
+		"existing":         result.Existing,
+		"spool_path":       "",
+		"finished_at":      &now,
+	})
+	if err != nil {
+		log.Printf("更新上传任务%d失败: %v", job.ID, err)
+		return
+	}
+	if !ok {
+		// 其他进程已重新领取该任务，暂存文件由其处理
+		log.Printf("上传任务%d的租约已失效，结果未记录", job.ID)
+		return
+	}
+	os.Remove(job.SpoolPath)
+}
+
+// keepLease 定期续期任务租约直到ctx结束，租约已被其他进程领取时调用cancel
+func (q *UploadQueue) keepLease(ctx context.Context, cancel context.CancelFunc, id uint) {
+	ticker := time.NewTicker(uploadJobLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := model.RenewUploadJobLease(id, q.owner, uploadJobLease)
+		if err != nil {
+			// 数据库暂时不可用时继续处理，租约到期前仍有机会续期
+			log.Printf("续期上传任务%d失败: %v", id, err)
+			continue
+		}
+		if !ok {
+			log.Printf("上传任务%d的租约已失效，取消上传", id)
+			cancel()
+			return
+		}
+	}
+}
+
+// handOff 暂存文件不在本节点时将任务恢复为待处理，不计入执行次数
+func (q *UploadQueue) handOff(job *model.UploadJob, cause error) {
+	ok, err := model.ReleaseUploadJob(job.ID, q.owner, map[string]interface{}{
+		"status":      model.UploadJobPending,
+		"attempts":    job.Attempts - 1,
+		"next_run_at": time.Now().Add(uploadJobRetryDelay),
+	})
+	if err != nil {
+		log.Printf("更新上传任务%d失败: %v", job.ID, err)
+		return
+	}
+	if ok {
+		log.Printf("上传任务%d的暂存文件不在本节点，交还给其他节点: %v", job.ID, cause)
+	}
+}
+
+// finish 记录任务失败，未达到最大重试次数时按指数退避重新排队
+// 最终失败的任务删除暂存文件
+func (q *UploadQueue) finish(job *model.UploadJob, cause error, retry bool) {
+	fields := map[string]interface{}{"error": truncate(cause.Error(), 1000)}
+
+	final := !retry || job.Attempts >= job.MaxAttempts
+	if !final {
+		delay := uploadJobBackoff(job.Attempts)
+		fields["status"] = model.UploadJobPending
+		fields["next_run_at"] = time.Now().Add(delay)
+		log.Printf("上传任务%d第%d次执行失败，%s后重试: %v", job.ID, job.Attempts, delay, cause)
+	} else {
+		now := time.Now()
+		fields["status"] = model.UploadJobFailed
+		fields["spool_path"] = ""
+		fields["finished_at"] = &now
+		log.Printf("上传任务%d失败: %v", job.ID, cause)
+	}
+
+	ok, err := model.ReleaseUploadJob(job.ID, q.owner, fields)
+	if err != nil {
+		log.Printf("更新上传任务%d失败: %v", job.ID, err)
+		return
+	}
+	if !ok {
+		log.Printf("上传任务%d的租约已失效，结果未记录", job.ID)
+		return
+	}
+	if final {
+		if err := os.Remove(job.SpoolPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除上传任务%d的暂存文件失败: %v", job.ID, err)
+		}
+	}
+}
+
+// uploadJobBackoff 第attempts次执行失败后到下次重试的等待时长，每次翻倍，不超过uploadJobMaxRetryDelay
+func uploadJobBackoff(attempts int) time.Duration {
+	delay := uploadJobRetryDelay
+	for i := 1; i < attempts && delay < uploadJobMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, uploadJobMaxRetryDelay)
+}
+
+// truncate 截断过长的字符串，避免超出数据库字段长度
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
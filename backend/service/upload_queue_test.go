@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telegram-photo/model"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// queueDB 记录执行的SQL语句和查询的database/sql驱动
+// UPDATE语句依次使用affected作为影响的行数，用完后为1；查询ID时返回ids，按ID查询任务时返回job
+type queueDB struct {
+	mu       sync.Mutex
+	affected []int64
+	ids      []int64
+	job      model.UploadJob
+	execs    []queueExec
+	queries  []queueExec
+}
+
+// queueExec 一次执行的语句及参数
+type queueExec struct {
+	query string
+	args  []driver.Value
+}
+
+type queueConn struct{ db *queueDB }
+type queueStmt struct {
+	db    *queueDB
+	query string
+}
+type queueTx struct{}
+type queueRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (d *queueDB) Connect(context.Context) (driver.Conn, error) { return &queueConn{db: d}, nil }
+func (d *queueDB) Driver() driver.Driver                        { return nil }
+
+func (c *queueConn) Prepare(query string) (driver.Stmt, error) {
+	return &queueStmt{db: c.db, query: query}, nil
+}
+func (c *queueConn) Close() error              { return nil }
+func (c *queueConn) Begin() (driver.Tx, error) { return queueTx{}, nil }
+
+func (s *queueStmt) Close() error  { return nil }
+func (s *queueStmt) NumInput() int { return -1 }
+
+func (s *queueStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.db
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.execs = append(d.execs, queueExec{query: s.query, args: args})
+	n := int64(1)
+	if len(d.affected) > 0 {
+		n, d.affected = d.affected[0], d.affected[1:]
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *queueStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.db
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = append(d.queries, queueExec{query: s.query, args: args})
+	if strings.HasPrefix(s.query, "SELECT `id`") {
+		rows := &queueRows{columns: []string{"id"}}
+		for _, id := range d.ids {
+			rows.values = append(rows.values, []driver.Value{id})
+		}
+		return rows, nil
+	}
+	return &queueRows{
+		columns: []string{"id", "spool_host", "attempts", "max_attempts"},
+		values:  [][]driver.Value{{args[0], d.job.SpoolHost, int64(d.job.Attempts), int64(d.job.MaxAttempts)}},
+	}, nil
+}
+
+func (queueTx) Commit() error   { return nil }
+func (queueTx) Rollback() error { return nil }
+
+func (r *queueRows) Columns() []string { return r.columns }
+func (r *queueRows) Close() error      { return nil }
+func (r *queueRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// useQueueDB 将model.DB替换为记录语句的连接
+func useQueueDB(t *testing.T, d *queueDB) {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sql.OpenDB(d),
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{Logger: logger.Discard, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = saved })
+}
+
+// updates 执行的UPDATE语句
+func (d *queueDB) updates() []queueExec {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var updates []queueExec
+	for _, e := range d.execs {
+		if strings.HasPrefix(e.query, "UPDATE") {
+			updates = append(updates, e)
+		}
+	}
+	return updates
+}
+
+// set 解析UPDATE语句中设置的字段，值为表达式时返回SQL文本
+func (e queueExec) set() map[string]driver.Value {
+	clause := e.query[strings.Index(e.query, " SET ")+5 : strings.Index(e.query, " WHERE ")]
+	fields := make(map[string]driver.Value)
+	arg := 0
+	for _, assignment := range strings.Split(clause, ",") {
+		name, value, _ := strings.Cut(assignment, "=")
+		name = strings.Trim(name, "`")
+		if value == "?" {
+			fields[name] = e.args[arg]
+			arg++
+		} else {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// where 更新条件及其参数
+func (e queueExec) where() (string, []driver.Value) {
+	clause := e.query[strings.Index(e.query, " WHERE ")+7:]
+	n := strings.Count(clause, "?")
+	return clause, e.args[len(e.args)-n:]
+}
+
+func TestUploadJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{6, 320 * time.Second},
+		{7, uploadJobMaxRetryDelay},
+		{64, uploadJobMaxRetryDelay},
+		{1 << 20, uploadJobMaxRetryDelay},
+	}
+	for _, tc := range tests {
+		if got := uploadJobBackoff(tc.attempts); got != tc.want {
+			t.Errorf("uploadJobBackoff(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestUploadQueueClaim(t *testing.T) {
+	tests := []struct {
+		name   string
+		shared bool
+		ids    []int64
+		// affected 依次领取各任务时更新的行数
+		affected []int64
+		wantJob  uint
+		// wantClaims 尝试领取的次数
+		wantClaims int
+	}{
+		{"claims first due job", false, []int64{1, 2}, []int64{1}, 1, 1},
+		{"skips jobs claimed by others", false, []int64{1, 2, 3}, []int64{0, 0, 1}, 3, 3},
+		{"all claimed by others", false, []int64{1, 2}, []int64{0, 0}, 0, 2},
+		{"no due jobs", false, nil, nil, 0, 0},
+		{"shared spool", true, []int64{4}, []int64{1}, 4, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &queueDB{ids: tc.ids, affected: tc.affected}
+			useQueueDB(t, d)
+			q := &UploadQueue{owner: "owner-a", host: "node-a", shared: tc.shared}
+
+			job, err := q.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got uint
+			if job != nil {
+				got = job.ID
+			}
+			if got != tc.wantJob {
+				t.Fatalf("claimed job %d, want %d", got, tc.wantJob)
+			}
+
+			// 查询到期任务时同样限定暂存节点
+			d.mu.Lock()
+			due := d.queries[0]
+			d.mu.Unlock()
+			if hostScoped := strings.Contains(due.query, "spool_host IN"); hostScoped == tc.shared {
+				t.Fatalf("due jobs query %q, shared %v", due.query, tc.shared)
+			}
+
+			claims := d.updates()
+			if len(claims) != tc.wantClaims {
+				t.Fatalf("%d claim attempts, want %d", len(claims), tc.wantClaims)
+			}
+			for i, claim := range claims {
+				fields := claim.set()
+				if fields["status"] != model.UploadJobProcessing || fields["locked_by"] != "owner-a" {
+					t.Fatalf("claim %d sets %v", i, fields)
+				}
+				if fields["attempts"] != "attempts + 1" {
+					t.Fatalf("claim %d sets attempts to %v", i, fields["attempts"])
+				}
+				until, _ := fields["locked_until"].(time.Time)
+				if d := time.Until(until); d <= uploadJobLease-time.Minute || d > uploadJobLease {
+					t.Fatalf("claim %d lease ends in %s, want %s", i, d, uploadJobLease)
+				}
+
+				where, args := claim.where()
+				if !strings.Contains(where, "status = ?") || !containsValue(args, model.UploadJobPending) || !containsValue(args, tc.ids[i]) {
+					t.Fatalf("claim %d where %q %v", i, where, args)
+				}
+				// 暂存目录不共享时只领取本节点和未记录节点的任务
+				if hostScoped := strings.Contains(where, "spool_host IN"); hostScoped == tc.shared {
+					t.Fatalf("claim %d where %q, shared %v", i, where, tc.shared)
+				}
+				if !tc.shared && (!containsValue(args, "node-a") || !containsValue(args, "")) {
+					t.Fatalf("claim %d host args %v", i, args)
+				}
+			}
+		})
+	}
+}
+
+// containsValue args中是否包含v
+func containsValue(args []driver.Value, v driver.Value) bool {
+	for _, arg := range args {
+		if arg == v {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSpoolFile 创建暂存文件，返回路径
+func writeSpoolFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "job")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUploadQueueFinish(t *testing.T) {
+	tests := []struct {
+		name        string
+		attempts    int
+		maxAttempts int
+		retry       bool
+		// leaseLost 租约已被其他进程领取
+		leaseLost  bool
+		wantStatus string
+		wantDelay  time.Duration
+		wantSpool  bool
+	}{
+		{"first failure retries", 1, 3, true, false, model.UploadJobPending, 10 * time.Second, true},
+		{"backs off exponentially", 2, 3, true, false, model.UploadJobPending, 20 * time.Second, true},
+		{"last attempt fails", 3, 3, true, false, model.UploadJobFailed, 0, false},
+		{"permanent error fails", 1, 3, false, false, model.UploadJobFailed, 0, false},
+		{"lease lost keeps spool", 3, 3, true, true, model.UploadJobFailed, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &queueDB{}
+			if tc.leaseLost {
+				d.affected = []int64{0}
+			}
+			useQueueDB(t, d)
+			q := &UploadQueue{owner: "owner-a", host: "node-a"}
+			job := &model.UploadJob{ID: 7, Attempts: tc.attempts, MaxAttempts: tc.maxAttempts, SpoolPath: writeSpoolFile(t)}
+
+			q.finish(job, errors.New("backend down"), tc.retry)
+
+			updates := d.updates()
+			if len(updates) != 1 {
+				t.Fatalf("%d updates, want 1", len(updates))
+			}
+			fields := updates[0].set()
+			if fields["status"] != tc.wantStatus || fields["error"] != "backend down" {
+				t.Fatalf("finish sets %v", fields)
+			}
+			if fields["locked_by"] != "" || fields["locked_until"] != nil {
+				t.Fatalf("finish keeps lease: %v", fields)
+			}
+			if tc.wantStatus == model.UploadJobPending {
+				next, _ := fields["next_run_at"].(time.Time)
+				if d := time.Until(next); d <= tc.wantDelay-time.Second || d > tc.wantDelay {
+					t.Fatalf("retry in %s, want %s", d, tc.wantDelay)
+				}
+			} else if fields["spool_path"] != "" || fields["finished_at"] == nil {
+				t.Fatalf("failed job sets %v", fields)
+			}
+
+			// 只更新本进程仍持有租约的任务
+			where, args := updates[0].where()
+			if !strings.Contains(where, "locked_by = ?") || !containsValue(args, "owner-a") || !containsValue(args, model.UploadJobProcessing) {
+				t.Fatalf("finish where %q %v", where, args)
+			}
+
+			_, err := os.Stat(job.SpoolPath)
+			if tc.wantSpool != (err == nil) {
+				t.Fatalf("spool file exists = %v, want %v", err == nil, tc.wantSpool)
+			}
+		})
+	}
+}
+
+func TestUploadQueueMissingSpool(t *testing.T) {
+	tests := []struct {
+		name      string
+		spoolHost string
+		shared    bool
+		// wantHandOff 交还给其他节点，不计入执行次数
+		wantHandOff bool
+	}{
+		{"job without host", "", false, true},
+		{"job on this node", "node-a", false, false},
+		{"job on another node", "node-b", false, true},
+		{"shared spool", "", true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &queueDB{}
+			useQueueDB(t, d)
+			q := &UploadQueue{owner: "owner-a", host: "node-a", shared: tc.shared}
+			job := &model.UploadJob{
+				ID:          7,
+				Attempts:    2,
+				MaxAttempts: 3,
+				SpoolHost:   tc.spoolHost,
+				SpoolPath:   filepath.Join(t.TempDir(), "missing"),
+			}
+
+			q.process(job)
+
+			updates := d.updates()
+			if len(updates) != 1 {
+				t.Fatalf("%d updates, want 1", len(updates))
+			}
+			fields := updates[0].set()
+			if tc.wantHandOff {
+				if fields["status"] != model.UploadJobPending || fields["attempts"] != int64(1) {
+					t.Fatalf("hand off sets %v", fields)
+				}
+			} else if fields["status"] != model.UploadJobFailed {
+				t.Fatalf("missing spool sets %v, want failed", fields)
+			}
+		})
+	}
+}
+
+func TestUploadQueueReclaim(t *testing.T) {
+	tests := []struct {
+		name       string
+		expired    int64
+		wantNotify bool
+	}{
+		{"expired leases", 2, true},
+		{"nothing expired", 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &queueDB{affected: []int64{tc.expired}}
+			useQueueDB(t, d)
+			q := &UploadQueue{owner: "owner-a", wake: make(chan struct{}, 1)}
+
+			if err := q.reclaim(); err != nil {
+				t.Fatal(err)
+			}
+
+			updates := d.updates()
+			if len(updates) != 1 {
+				t.Fatalf("%d updates, want 1", len(updates))
+			}
+			fields := updates[0].set()
+			if fields["status"] != model.UploadJobPending || fields["locked_by"] != "" || fields["locked_until"] != nil {
+				t.Fatalf("reclaim sets %v", fields)
+			}
+			// 租约到期或没有租约的处理中任务，与持有者无关
+			where, args := updates[0].where()
+			if !strings.Contains(where, "locked_until IS NULL OR locked_until < ?") || !containsValue(args, model.UploadJobProcessing) {
+				t.Fatalf("reclaim where %q %v", where, args)
+			}
+			if strings.Contains(where, "locked_by") {
+				t.Fatalf("reclaim filters by owner: %q", where)
+			}
+
+			select {
+			case <-q.wake:
+				if !tc.wantNotify {
+					t.Fatal("reclaim notified without expired jobs")
+				}
+			default:
+				if tc.wantNotify {
+					t.Fatal("reclaim did not notify workers")
+				}
+			}
+		})
+	}
+}
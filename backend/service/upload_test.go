@@ -0,0 +1,28 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReusableUploadModes(t *testing.T) {
+	saved := viper.Get("telegram.upload_mode")
+	t.Cleanup(func() { viper.Set("telegram.upload_mode", saved) })
+
+	for _, tc := range []struct {
+		mode, defaultMode string
+		want              []string
+	}{
+		{UploadModeDocument, UploadModePhoto, []string{UploadModeDocument}},
+		{UploadModePhoto, UploadModeDocument, nil},
+		{"", UploadModeDocument, []string{UploadModeDocument}},
+		{"", UploadModePhoto, nil},
+	} {
+		viper.Set("telegram.upload_mode", tc.defaultMode)
+		if got := reusableUploadModes(tc.mode); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("reusableUploadModes(%q) with default %q = %v, want %v", tc.mode, tc.defaultMode, got, tc.want)
+		}
+	}
+}